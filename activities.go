@@ -2,68 +2,202 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/mailgun/mailgun-go"
-	"github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/charge"
+	"go.temporal.io/sdk/temporal"
 )
 
 type Activities struct {
-	StripeKey     string
-	MailgunDomain string
-	MailgunKey    string
+	Catalog   Catalog
+	Payments  PaymentProvider
+	Inventory InventoryStore
+	Labels    LabelProvider
+	Emails    EmailSender
+	// StoreURL is where links in emails point, DefaultStoreURL if empty.
+	StoreURL string
 }
 
-func (a *Activities) CreateStripeCharge(_ context.Context, cart CartState) error {
-	stripe.Key = a.StripeKey
-	var amount float32 = 0
+// LookupVariant fetches a variant from the catalog when it is first added
+// to a cart. An unknown SKU is not retried.
+func (a *Activities) LookupVariant(ctx context.Context, sku string) (ProductVariant, error) {
+	variant, ok, err := a.Catalog.Variant(ctx, sku)
+	if err != nil {
+		return ProductVariant{}, err
+	}
+	if !ok {
+		return ProductVariant{}, invalidRequest("unknown SKU %q", sku)
+	}
+	return variant, nil
+}
+
+// AuthorizePayment places a hold for the cart total on the customer's card.
+// The returned payment must later be captured or cancelled. The idempotency
+// key must be the same on every retry of one checkout attempt.
+func (a *Activities) AuthorizePayment(ctx context.Context, cart CartState, idempotencyKey string) (Payment, error) {
+	amount := cart.Pricing.Total
+	if amount.Amount <= 0 {
+		return Payment{}, invalidRequest("nothing to charge for cart total %s", amount)
+	}
+
 	var description string = ""
-	for _, item := range cart.Items {
-		var product Product
-		for _, _product := range Products {
-			if _product.Id == item.ProductId {
-				product = _product
-				break
-			}
-		}
-		amount += float32(item.Quantity) * product.Price
+	for _, line := range cart.Pricing.Lines {
 		if len(description) > 0 {
 			description += ", "
 		}
-		description += product.Name
+		description += line.Name
 	}
 
-	_, err := charge.New(&stripe.ChargeParams{
-		Amount:       stripe.Int64(int64(amount * 100)),
-		Currency:     stripe.String(string(stripe.CurrencyUSD)),
-		Description:  stripe.String(description),
-		Source:       &stripe.SourceParams{Token: stripe.String("tok_visa")},
-		ReceiptEmail: stripe.String(cart.Email),
+	payment, err := a.Payments.Authorize(ctx, PaymentRequest{
+		Amount:         amount,
+		Description:    description,
+		Email:          cart.Email,
+		IdempotencyKey: idempotencyKey,
 	})
+	if err != nil {
+		fmt.Println("Payment authorization err: " + err.Error())
+	}
+	if errors.Is(err, ErrPaymentDeclined) {
+		return Payment{}, temporal.NewNonRetryableApplicationError(err.Error(), ErrorTypePaymentDeclined, nil)
+	}
+
+	return payment, err
+}
+
+func (a *Activities) CapturePayment(ctx context.Context, paymentID string, idempotencyKey string) (Payment, error) {
+	payment, err := a.Payments.Capture(ctx, paymentID, idempotencyKey)
+	if err != nil {
+		fmt.Println("Payment capture err: " + err.Error())
+	}
+
+	return payment, err
+}
 
+func (a *Activities) CancelPayment(ctx context.Context, paymentID string, idempotencyKey string) (Payment, error) {
+	payment, err := a.Payments.Void(ctx, paymentID, idempotencyKey)
 	if err != nil {
-		fmt.Println("Stripe err: " + err.Error())
+		fmt.Println("Payment cancellation err: " + err.Error())
+	}
+
+	return payment, err
+}
+
+// RefundPayment gives amount of a captured payment back to the customer. A
+// refund of more than is left of the payment is not retried.
+func (a *Activities) RefundPayment(ctx context.Context, paymentID string, amount Money, idempotencyKey string) (Payment, error) {
+	payment, err := a.Payments.Refund(ctx, paymentID, amount, idempotencyKey)
+	if err != nil {
+		fmt.Println("Payment refund err: " + err.Error())
+	}
+	if errors.Is(err, ErrRefundExceedsCapture) {
+		return Payment{}, temporal.NewNonRetryableApplicationError(err.Error(), ErrorTypeRefundExceeded, nil)
+	}
+
+	return payment, err
+}
+
+// SendAbandonedCartEmail sends one step of the abandoned cart campaign.
+func (a *Activities) SendAbandonedCartEmail(ctx context.Context, reminder AbandonedCartReminder) error {
+	if reminder.Email == "" {
+		return nil
+	}
+	email, err := RenderEmail(EmailAbandonedCart, AbandonedCartEmail(reminder, a.storeURL()))
+	if err != nil {
+		return err
+	}
+
+	return a.sendEmail(ctx, reminder.Email, email)
+}
+
+// ReserveInventory sets the number of units of a SKU held by a cart.
+// Running out of stock is not retried: the shopper has to change the cart.
+func (a *Activities) ReserveInventory(ctx context.Context, cartID string, sku string, quantity int) error {
+	err := a.Inventory.Reserve(ctx, cartID, sku, quantity)
+	if errors.Is(err, ErrOutOfStock) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), ErrorTypeOutOfStock, nil)
+	}
+	if errors.Is(err, ErrInvalidQuantity) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), ErrorTypeInvalidQuantity, nil)
 	}
 
 	return err
 }
 
-func (a *Activities) SendAbandonedCartEmail(_ context.Context, email string) error {
-	if email == "" {
+func (a *Activities) ReleaseInventory(ctx context.Context, cartID string) error {
+	return a.Inventory.Release(ctx, cartID)
+}
+
+func (a *Activities) CommitInventory(ctx context.Context, cartID string) error {
+	return a.Inventory.Commit(ctx, cartID)
+}
+
+// RestockInventory puts units that passed a return's inspection back on
+// sale.
+func (a *Activities) RestockInventory(ctx context.Context, returnID string, sku string, quantity int) error {
+	return a.Inventory.Restock(ctx, returnID, sku, quantity)
+}
+
+// IssueReturnLabel gets the prepaid label the customer sends a return back
+// with.
+func (a *Activities) IssueReturnLabel(ctx context.Context, returnID string) (ReturnLabel, error) {
+	label, err := a.Labels.CreateReturnLabel(ctx, returnID)
+	if err != nil {
+		fmt.Println("Return label err: " + err.Error())
+	}
+
+	return label, err
+}
+
+func (a *Activities) SendOrderConfirmationEmail(ctx context.Context, order Order) error {
+	if order.Email == "" {
+		return nil
+	}
+	email, err := RenderEmail(EmailOrderConfirmation, OrderConfirmationEmail(order, a.storeURL()))
+	if err != nil {
+		return err
+	}
+
+	return a.sendEmail(ctx, order.Email, email)
+}
+
+// SendShippingNotificationEmail tells the customer their order is on its way.
+func (a *Activities) SendShippingNotificationEmail(ctx context.Context, order Order) error {
+	if order.Email == "" {
+		return nil
+	}
+	email, err := RenderEmail(EmailShippingNotification, ShippingNotificationEmail(order, a.storeURL()))
+	if err != nil {
+		return err
+	}
+
+	return a.sendEmail(ctx, order.Email, email)
+}
+
+// SendOrderCancellationEmail tells the customer their order was cancelled
+// and what happened to their payment.
+func (a *Activities) SendOrderCancellationEmail(ctx context.Context, order Order) error {
+	if order.Email == "" {
 		return nil
 	}
-	mg := mailgun.NewMailgun(a.MailgunDomain, a.MailgunKey)
-	m := mg.NewMessage(
-		"noreply@"+a.MailgunDomain,
-		"You've abandoned your shopping cart!",
-		"Go to http://localhost:8080 to finish checking out!",
-		email,
-	)
-	_, _, err := mg.Send(m)
+	email, err := RenderEmail(EmailOrderCancellation, OrderCancellationEmail(order, a.storeURL()))
 	if err != nil {
-		fmt.Println("Mailgun err: " + err.Error())
 		return err
 	}
 
+	return a.sendEmail(ctx, order.Email, email)
+}
+
+func (a *Activities) sendEmail(ctx context.Context, to string, email Email) error {
+	err := a.Emails.Send(ctx, to, email)
+	if err != nil {
+		fmt.Println("Email err: " + err.Error())
+	}
+
 	return err
 }
+
+func (a *Activities) storeURL() string {
+	if a.StoreURL == "" {
+		return DefaultStoreURL
+	}
+	return a.StoreURL
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"strings"
+	"temporal-ecommerce/app"
+)
+
+type (
+	// ProductRequest is a product as an admin creates or replaces it.
+	ProductRequest struct {
+		// Id is required when creating a product, and must match the URL
+		// when replacing one, if set.
+		Id          *int
+		Name        string
+		Description string
+		Image       string
+		Prices      []app.Money
+		Variants    []VariantRequest
+	}
+
+	// VariantRequest is a variant as an admin sends it. Leaving Stock out
+	// keeps the stock the variant already has, or none for a new one.
+	VariantRequest struct {
+		app.Variant
+		Stock *int
+	}
+
+	// StockRequest sets how many units of a variant are on hand.
+	StockRequest struct {
+		Stock *int
+	}
+
+	// adminHandler serves an admin route on behalf of the named admin.
+	adminHandler func(w http.ResponseWriter, r *http.Request, editor app.CatalogEditor, admin string)
+)
+
+// adminTokens maps the bearer tokens that may use the admin routes to the
+// names of the admins they belong to.
+var adminTokens map[string]string
+
+// parseAdminTokens reads ADMIN_TOKENS, a comma separated list of
+// name:token pairs such as "alice:s3cret,bob:hunter22".
+func parseAdminTokens(s string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("ADMIN_TOKENS: want name:token, got %q", pair)
+		}
+		if _, ok := tokens[token]; ok {
+			return nil, fmt.Errorf("ADMIN_TOKENS: %s shares a token with another admin", name)
+		}
+		tokens[token] = name
+	}
+	return tokens, nil
+}
+
+// authenticateAdmin returns the admin whose bearer token the request
+// carries. Every token is compared, in constant time, so the response time
+// gives nothing away.
+func authenticateAdmin(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	admin := ""
+	for candidate, name := range adminTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(candidate)) == 1 {
+			admin = name
+		}
+	}
+	return admin, admin != ""
+}
+
+// requireStaff only lets admins through, for the order routes that the
+// store's staff use, such as confirming fulfillment or approving a return.
+func requireStaff(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticateAdmin(r); !ok {
+			writeAdminTokenRequired(w)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAdmin only lets admins through, and only if the catalog can be
+// edited at all.
+func requireAdmin(next adminHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := authenticateAdmin(r)
+		if !ok {
+			writeAdminTokenRequired(w)
+			return
+		}
+		editor, ok := catalog.(app.CatalogEditor)
+		if !ok {
+			WriteErrorWithStatus(w, http.StatusNotImplemented, errors.New("the catalog is read-only, set CATALOG_DB to edit it"))
+			return
+		}
+		next(w, r, editor, admin)
+	}
+}
+
+func writeAdminTokenRequired(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+	WriteErrorWithStatus(w, http.StatusUnauthorized, errors.New("admin token required"))
+}
+
+// AdminGetProductsHandler lists every product, deleted ones included.
+func AdminGetProductsHandler(w http.ResponseWriter, r *http.Request, editor app.CatalogEditor, admin string) {
+	records, err := editor.Records(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := make(map[string]interface{})
+	res["products"] = records
+	json.NewEncoder(w).Encode(res)
+}
+
+func AdminCreateProductHandler(w http.ResponseWriter, r *http.Request, editor app.CatalogEditor, admin string) {
+	var body ProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Id == nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, errors.New("Id is required"))
+		return
+	}
+
+	product, _ := body.product(*body.Id)
+	if err := app.ValidateProduct(product); err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	record, err := editor.Create(r.Context(), admin, product)
+	if err != nil {
+		WriteCatalogError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(record)
+}
+
+func AdminUpdateProductHandler(w http.ResponseWriter, r *http.Request, editor app.CatalogEditor, admin string) {
+	id, err := productIDParam(r)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+	var body ProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Id != nil && *body.Id != id {
+		WriteErrorWithStatus(w, http.StatusBadRequest, fmt.Errorf("Id %d does not match product %d", *body.Id, id))
+		return
+	}
+
+	product, stocked := body.product(id)
+	if err := app.ValidateProduct(product); err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	record, err := editor.Update(r.Context(), admin, product, stocked)
+	if err != nil {
+		WriteCatalogError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(record)
+}
+
+// AdminDeleteProductHandler takes a product off sale. Carts that already
+// hold it keep their copy, so they are still priced the same.
+func AdminDeleteProductHandler(w http.ResponseWriter, r *http.Request, editor app.CatalogEditor, admin string) {
+	id, err := productIDParam(r)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	record, err := editor.Delete(r.Context(), admin, id)
+	if err != nil {
+		WriteCatalogError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(record)
+}
+
+// AdminSetStockHandler sets how many units of a variant are on hand, such
+// as after a delivery or a stock count.
+func AdminSetStockHandler(w http.ResponseWriter, r *http.Request, editor app.CatalogEditor, admin string) {
+	var body StockRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+	if body.Stock == nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, errors.New("Stock is required"))
+		return
+	}
+
+	record, err := editor.SetStock(r.Context(), admin, mux.Vars(r)["sku"], *body.Stock)
+	if err != nil {
+		WriteCatalogError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(record)
+}
+
+// AdminAuditLogHandler lists the changes to the catalog, or with
+// ?productId= those to one product.
+func AdminAuditLogHandler(w http.ResponseWriter, r *http.Request, editor app.CatalogEditor, admin string) {
+	var productID *int
+	if s := r.URL.Query().Get("productId"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			WriteErrorWithStatus(w, http.StatusBadRequest, fmt.Errorf("invalid productId %q", s))
+			return
+		}
+		productID = &id
+	}
+
+	entries, err := editor.AuditLog(r.Context(), productID)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := make(map[string]interface{})
+	res["entries"] = entries
+	json.NewEncoder(w).Encode(res)
+}
+
+// product is the product the request describes, along with the SKUs of the
+// variants it sets the stock of. The others have no Stock.
+func (body ProductRequest) product(id int) (app.Product, []string) {
+	variants := make([]app.Variant, 0, len(body.Variants))
+	var stocked []string
+	for _, request := range body.Variants {
+		variant := request.Variant
+		if request.Stock != nil {
+			variant.Stock = *request.Stock
+			stocked = append(stocked, variant.SKU)
+		}
+		variants = append(variants, variant)
+	}
+	product := app.Product{
+		Id:          id,
+		Name:        body.Name,
+		Description: body.Description,
+		Image:       body.Image,
+		Prices:      body.Prices,
+		Variants:    variants,
+	}
+	return product, stocked
+}
+
+func productIDParam(r *http.Request) (int, error) {
+	s := mux.Vars(r)["productID"]
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid product ID %q", s)
+	}
+	return id, nil
+}
+
+// WriteCatalogError reports why a change to the catalog failed.
+func WriteCatalogError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, app.ErrProductNotFound), errors.Is(err, app.ErrVariantNotFound):
+		WriteErrorWithStatus(w, http.StatusNotFound, err)
+	case errors.Is(err, app.ErrProductExists), errors.Is(err, app.ErrSKUTaken):
+		WriteErrorWithStatus(w, http.StatusConflict, err)
+	case errors.Is(err, app.ErrInvalidQuantity):
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+	default:
+		WriteError(w, err)
+	}
+}
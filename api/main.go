@@ -3,14 +3,19 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/bojanz/httpx"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/client"
+	temporalsdk "go.temporal.io/sdk/temporal"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"temporal-ecommerce/app"
 	"time"
 )
@@ -18,24 +23,83 @@ import (
 type (
 	ErrorResponse struct {
 		Message string
+		// Type is the error type reported by the cart workflow, if any.
+		Type string `json:",omitempty"`
+		// Checkout is the outcome of a failed checkout.
+		Checkout *app.CheckoutOutcome `json:",omitempty"`
+		// StartNewCart tells the frontend to drop the cart and create a new one.
+		StartNewCart bool `json:",omitempty"`
 	}
 
 	UpdateEmailRequest struct {
 		Email string
 	}
 
+	AddToCartRequest struct {
+		// SKU is the variant to add, such as "IP12PRO-256-GRA".
+		SKU      string
+		Quantity int
+		Currency string
+	}
+
 	CheckoutRequest struct {
-		Email string
+		Email           string
+		ShippingAddress app.ShippingAddress
+	}
+
+	CouponRequest struct {
+		Code string
+	}
+
+	FulfillmentRequest struct {
+		Confirmed      bool
+		Carrier        string
+		TrackingNumber string
+	}
+
+	CancelOrderRequest struct {
+		Reason string
+	}
+
+	ReturnApprovalRequest struct {
+		Approved bool
+		Reason   string
+	}
+
+	ReturnReceiptRequest struct {
+		Accepted []app.CartItem
 	}
 )
 
 var (
 	HTTPPort = os.Getenv("PORT")
 	temporal client.Client
+	// cartConfig is passed to every new cart, see app.CartConfigFromEnv.
+	cartConfig app.CartConfig
+	// catalog is the product list, see app.OpenCatalog.
+	catalog app.Catalog
 )
 
 func main() {
 	var err error
+	cartConfig, err = app.CartConfigFromEnv(app.DefaultCartConfig())
+	if err != nil {
+		log.Fatalln("invalid cart configuration", err)
+	}
+
+	catalog, err = app.OpenCatalog(context.Background(), os.Getenv("CATALOG_FILE"), os.Getenv("CATALOG_DB"))
+	if err != nil {
+		log.Fatalln("unable to open catalog", err)
+	}
+
+	adminTokens, err = parseAdminTokens(os.Getenv("ADMIN_TOKENS"))
+	if err != nil {
+		log.Fatalln("invalid admin tokens", err)
+	}
+	if len(adminTokens) == 0 {
+		log.Println("ADMIN_TOKENS is not set, so nobody can use the admin or staff routes")
+	}
+
 	temporal, err = client.NewClient(client.Options{})
 	if err != nil {
 		log.Fatalln("unable to create Temporal client", err)
@@ -50,16 +114,35 @@ func main() {
 	r.Handle("/cart/{workflowID}/remove", http.HandlerFunc(RemoveFromCartHandler)).Methods("PUT")
 	r.Handle("/cart/{workflowID}/checkout", http.HandlerFunc(CheckoutHandler)).Methods("PUT")
 	r.Handle("/cart/{workflowID}/email", http.HandlerFunc(UpdateEmailHandler)).Methods("PUT")
+	r.Handle("/cart/{workflowID}/coupon", http.HandlerFunc(ApplyCouponHandler)).Methods("PUT")
+	r.Handle("/cart/{workflowID}/fulfillment", requireStaff(FulfillmentHandler)).Methods("PUT")
+	r.Handle("/orders/{orderID}", http.HandlerFunc(GetOrderHandler)).Methods("GET")
+	r.Handle("/orders/{orderID}/fulfillment", requireStaff(OrderFulfillmentHandler)).Methods("PUT")
+	r.Handle("/orders/{orderID}/delivery", requireStaff(OrderDeliveryHandler)).Methods("PUT")
+	r.Handle("/orders/{orderID}/cancel", http.HandlerFunc(CancelOrderHandler)).Methods("PUT")
+	r.Handle("/orders/{orderID}/refunds", requireStaff(RefundOrderHandler)).Methods("POST")
+	r.Handle("/orders/{orderID}/returns", http.HandlerFunc(GetReturnsHandler)).Methods("GET")
+	r.Handle("/orders/{orderID}/returns", http.HandlerFunc(RequestReturnHandler)).Methods("POST")
+	r.Handle("/orders/{orderID}/returns/{returnID}", http.HandlerFunc(GetReturnHandler)).Methods("GET")
+	r.Handle("/orders/{orderID}/returns/{returnID}/approval", requireStaff(ReturnApprovalHandler)).Methods("PUT")
+	r.Handle("/orders/{orderID}/returns/{returnID}/receipt", requireStaff(ReturnReceiptHandler)).Methods("PUT")
+
+	r.Handle("/admin/products", requireAdmin(AdminGetProductsHandler)).Methods("GET")
+	r.Handle("/admin/products", requireAdmin(AdminCreateProductHandler)).Methods("POST")
+	r.Handle("/admin/products/{productID}", requireAdmin(AdminUpdateProductHandler)).Methods("PUT")
+	r.Handle("/admin/products/{productID}", requireAdmin(AdminDeleteProductHandler)).Methods("DELETE")
+	r.Handle("/admin/stock/{sku}", requireAdmin(AdminSetStockHandler)).Methods("PUT")
+	r.Handle("/admin/audit", requireAdmin(AdminAuditLogHandler)).Methods("GET")
 
 	r.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
 
-	var cors = handlers.CORS(handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"}), handlers.AllowedMethods([]string{"GET", "POST", "PUT", "HEAD", "OPTIONS"}), handlers.AllowedOrigins([]string{"*"}))
+	var cors = handlers.CORS(handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"}), handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS"}), handlers.AllowedOrigins([]string{"*"}))
 
 	http.Handle("/", cors(r))
 	server := httpx.NewServer(":"+HTTPPort, http.DefaultServeMux)
 	server.WriteTimeout = time.Second * 240
 
-	log.Println("Starting server on port: "+HTTPPort)
+	log.Println("Starting server on port: " + HTTPPort)
 
 	err = server.Start()
 	if err != nil {
@@ -68,14 +151,33 @@ func main() {
 }
 
 func GetProductsHandler(w http.ResponseWriter, r *http.Request) {
+	currency, err := currencyParam(r)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	products, err := catalog.Products(r.Context())
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
 	res := make(map[string]interface{})
-	res["products"] = app.Products
+	res["currency"] = currency
+	res["products"] = app.ProductsIn(products, currency)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
 
 func CreateCartHandler(w http.ResponseWriter, r *http.Request) {
+	currency, err := currencyParam(r)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
 	workflowID := "CART-" + fmt.Sprintf("%d", time.Now().Unix())
 
 	options := client.StartWorkflowOptions{
@@ -83,8 +185,14 @@ func CreateCartHandler(w http.ResponseWriter, r *http.Request) {
 		TaskQueue: "CART_TASK_QUEUE",
 	}
 
-	cart := app.CartState{Items: make([]app.CartItem, 0)}
-	we, err := temporal.ExecuteWorkflow(context.Background(), options, app.CartWorkflow, cart)
+	// Emails about the cart are written in the shopper's language
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale = r.Header.Get("Accept-Language")
+	}
+
+	cart := app.CartState{Items: make([]app.CartItem, 0), Currency: currency, Locale: app.MatchLocale(locale)}
+	we, err := temporal.ExecuteWorkflow(context.Background(), options, app.CartWorkflow, cart, cartConfig)
 	if err != nil {
 		WriteError(w, err)
 		return
@@ -100,41 +208,50 @@ func CreateCartHandler(w http.ResponseWriter, r *http.Request) {
 
 func GetCartHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	response, err := temporal.QueryWorkflow(context.Background(), vars["workflowID"], "", "getCart")
+	cart, err := queryCart(vars["workflowID"])
 	if err != nil {
-		WriteError(w, err)
+		WriteCartError(w, err)
 		return
 	}
-	var res interface{}
-	if err := response.Get(&res); err != nil {
-		WriteError(w, err)
+	if cart.Status == app.CartExpired {
+		WriteCartError(w, app.ErrCartExpired)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(cart)
 }
 
 func AddToCartHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	var item app.CartItem
-	err := json.NewDecoder(r.Body).Decode(&item)
+	var body AddToCartRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
-		WriteError(w, err)
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
 		return
 	}
 
-	update := app.AddToCartSignal{Route: app.RouteTypes.ADD_TO_CART, Item: item}
+	update := app.AddToCartSignal{
+		Route:    app.RouteTypes.ADD_TO_CART,
+		Item:     app.CartItem{SKU: body.SKU, Quantity: body.Quantity},
+		Currency: strings.ToUpper(body.Currency),
+	}
+	if err := app.ValidateItem(r.Context(), catalog, update.Item); err != nil {
+		WriteCartError(w, err)
+		return
+	}
 
-	err = temporal.SignalWorkflow(context.Background(), vars["workflowID"], "", app.SignalChannels.ADD_TO_CART_CHANNEL, update)
+	var cart app.CartState
+	err = updateCart(r.Context(), vars["workflowID"], app.UpdateNames.ADD_TO_CART, update, &cart)
 	if err != nil {
-		WriteError(w, err)
+		WriteCartError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	res := make(map[string]interface{})
 	res["ok"] = 1
+	res["cart"] = cart
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -143,21 +260,29 @@ func RemoveFromCartHandler(w http.ResponseWriter, r *http.Request) {
 	var item app.CartItem
 	err := json.NewDecoder(r.Body).Decode(&item)
 	if err != nil {
-		WriteError(w, err)
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
 		return
 	}
 
 	update := app.RemoveFromCartSignal{Route: app.RouteTypes.REMOVE_FROM_CART, Item: item}
+	// The variant may have left the catalog since it was added, so only
+	// the quantity is checked here.
+	if err := app.ValidateQuantity(update.Item); err != nil {
+		WriteCartError(w, err)
+		return
+	}
 
-	err = temporal.SignalWorkflow(context.Background(), vars["workflowID"], "", app.SignalChannels.REMOVE_FROM_CART_CHANNEL, update)
+	var cart app.CartState
+	err = updateCart(r.Context(), vars["workflowID"], app.UpdateNames.REMOVE_FROM_CART, update, &cart)
 	if err != nil {
-		WriteError(w, err)
+		WriteCartError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	res := make(map[string]interface{})
 	res["ok"] = 1
+	res["cart"] = cart
 	json.NewEncoder(w).Encode(res)
 }
 
@@ -167,7 +292,16 @@ func UpdateEmailHandler(w http.ResponseWriter, r *http.Request) {
 	var body UpdateEmailRequest
 	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
-		WriteError(w, err)
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := app.ValidateEmail(body.Email); err != nil {
+		WriteCartError(w, err)
+		return
+	}
+	if _, err := requireCartStatus(vars["workflowID"], app.CartOpen); err != nil {
+		WriteCartError(w, err)
 		return
 	}
 
@@ -185,19 +319,338 @@ func UpdateEmailHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+func ApplyCouponHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var body CouponRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Check the code against the current cart first so the shopper hears
+	// about a bad coupon now. The workflow checks it again when applying it.
+	cart, err := requireCartStatus(vars["workflowID"], app.CartOpen)
+	if err != nil {
+		WriteCartError(w, err)
+		return
+	}
+	if body.Code != "" {
+		_, err = app.CheckCoupon(body.Code, cart, time.Now())
+		if errors.Is(err, app.ErrCouponNotFound) {
+			WriteErrorWithStatus(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			WriteErrorWithStatus(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	applyCoupon := app.ApplyCouponSignal{Route: app.RouteTypes.APPLY_COUPON, Code: body.Code}
+
+	err = temporal.SignalWorkflow(context.Background(), vars["workflowID"], "", app.SignalChannels.APPLY_COUPON_CHANNEL, applyCoupon)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := make(map[string]interface{})
+	res["ok"] = 1
+	json.NewEncoder(w).Encode(res)
+}
+
 func CheckoutHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	var body CheckoutRequest
 	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	checkout := app.CheckoutSignal{Route: app.RouteTypes.CHECKOUT, Email: body.Email, ShippingAddress: body.ShippingAddress}
+
+	// Wait for the checkout to finish so the shopper learns straight away
+	// whether the order went through.
+	var outcome app.CheckoutOutcome
+	err = updateCart(r.Context(), vars["workflowID"], app.UpdateNames.CHECKOUT, checkout, &outcome)
+	if err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := make(map[string]interface{})
+	res["orderID"] = outcome.OrderID
+	res["checkout"] = outcome
+	json.NewEncoder(w).Encode(res)
+}
+
+// FulfillmentHandler confirms fulfillment of the order a cart was checked
+// out into. It is kept for clients that only know the cart.
+func FulfillmentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var body FulfillmentRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, err := requireCartStatus(vars["workflowID"], app.CartCheckedOut)
+	if err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	fulfillOrder(w, cart.Order.ID, body)
+}
+
+func GetOrderHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	order, err := queryOrder(vars["orderID"])
+	if err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(order)
+}
+
+func OrderFulfillmentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var body FulfillmentRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fulfillOrder(w, vars["orderID"], body)
+}
+
+func OrderDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if _, err := requireOrderStatus(vars["orderID"], app.OrderShipped); err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	delivery := app.DeliverySignal{Route: app.RouteTypes.DELIVERY}
+	err := temporal.SignalWorkflow(context.Background(), vars["orderID"], "", app.SignalChannels.DELIVERY_CHANNEL, delivery)
 	if err != nil {
 		WriteError(w, err)
 		return
 	}
 
-	checkout := app.CheckoutSignal{Route: app.RouteTypes.CHECKOUT, Email: body.Email}
+	w.WriteHeader(http.StatusOK)
+	res := make(map[string]interface{})
+	res["sent"] = true
+	json.NewEncoder(w).Encode(res)
+}
+
+// CancelOrderHandler cancels an order that has not shipped. The body is
+// optional.
+func CancelOrderHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var body CancelOrderRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := requireOrderStatus(vars["orderID"], app.OrderPlaced); err != nil {
+		WriteCartError(w, err)
+		return
+	}
 
-	err = temporal.SignalWorkflow(context.Background(), vars["workflowID"], "", app.SignalChannels.CHECKOUT_CHANNEL, checkout)
+	cancel := app.CancelOrderSignal{Route: app.RouteTypes.CANCEL_ORDER, Reason: body.Reason}
+	err = temporal.SignalWorkflow(context.Background(), vars["orderID"], "", app.SignalChannels.CANCEL_ORDER_CHANNEL, cancel)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := make(map[string]interface{})
+	res["sent"] = true
+	json.NewEncoder(w).Encode(res)
+}
+
+// RefundOrderHandler refunds some or all of an order's captured payment. A
+// request sent again with the same Idempotency-Key header is refunded once.
+func RefundOrderHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var body app.RefundRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var refund app.LedgerEntry
+	err = updateOrder(r.Context(), vars["orderID"], r.Header.Get("Idempotency-Key"), app.UpdateNames.REFUND_ORDER, body, &refund)
+	if err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(refund)
+}
+
+// GetReturnsHandler lists the returns of an order, each as it stands now.
+func GetReturnsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	order, err := queryOrder(vars["orderID"])
+	if err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	returns := make([]app.Return, 0, len(order.Returns))
+	for _, ret := range order.Returns {
+		// The order only learns how a return ended once it has.
+		if current, err := queryReturn(order.ID, ret.ID); err == nil {
+			ret = current
+		}
+		returns = append(returns, ret)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(returns)
+}
+
+// RequestReturnHandler opens a return for some of the items of a delivered
+// order.
+func RequestReturnHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var body app.ReturnRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var ret app.Return
+	err = updateOrder(r.Context(), vars["orderID"], "", app.UpdateNames.REQUEST_RETURN, body, &ret)
+	if err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ret)
+}
+
+func GetReturnHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	ret, err := queryReturn(vars["orderID"], vars["returnID"])
+	if err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ret)
+}
+
+func ReturnApprovalHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var body ReturnApprovalRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := requireReturnStatus(vars["orderID"], vars["returnID"], app.ReturnRequested); err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	approval := app.ReturnApprovalSignal{
+		Route:    app.RouteTypes.RETURN_APPROVAL,
+		Approved: body.Approved,
+		Reason:   body.Reason,
+	}
+	err = temporal.SignalWorkflow(context.Background(), vars["returnID"], "", app.SignalChannels.RETURN_APPROVAL_CHANNEL, approval)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := make(map[string]interface{})
+	res["sent"] = true
+	json.NewEncoder(w).Encode(res)
+}
+
+// ReturnReceiptHandler records that a return arrived, and which of its items
+// passed inspection.
+func ReturnReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	var body ReturnReceiptRequest
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		WriteErrorWithStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ret, err := requireReturnStatus(vars["orderID"], vars["returnID"], app.ReturnLabelIssued)
+	if err != nil {
+		WriteCartError(w, err)
+		return
+	}
+	if err := ret.ValidateReceipt(body.Accepted); err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	receipt := app.ReturnReceiptSignal{Route: app.RouteTypes.RETURN_RECEIPT, Accepted: body.Accepted}
+	err = temporal.SignalWorkflow(context.Background(), vars["returnID"], "", app.SignalChannels.RETURN_RECEIPT_CHANNEL, receipt)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	res := make(map[string]interface{})
+	res["sent"] = true
+	json.NewEncoder(w).Encode(res)
+}
+
+func fulfillOrder(w http.ResponseWriter, orderID string, body FulfillmentRequest) {
+	if _, err := requireOrderStatus(orderID, app.OrderPlaced); err != nil {
+		WriteCartError(w, err)
+		return
+	}
+
+	fulfillment := app.FulfillmentSignal{
+		Route:          app.RouteTypes.FULFILLMENT,
+		Confirmed:      body.Confirmed,
+		Carrier:        body.Carrier,
+		TrackingNumber: body.TrackingNumber,
+	}
+
+	err := temporal.SignalWorkflow(context.Background(), orderID, "", app.SignalChannels.FULFILLMENT_CHANNEL, fulfillment)
 	if err != nil {
 		WriteError(w, err)
 		return
@@ -216,7 +669,193 @@ func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func WriteError(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusInternalServerError)
+	WriteErrorWithStatus(w, http.StatusInternalServerError, err)
+}
+
+func WriteErrorWithStatus(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
 	res := ErrorResponse{Message: err.Error()}
 	json.NewEncoder(w).Encode(res)
 }
+
+// cartErrorStatuses maps the error types of cart commands to HTTP statuses.
+var cartErrorStatuses = map[string]int{
+	app.ErrorTypeInvalidRequest:  http.StatusBadRequest,
+	app.ErrorTypeInvalidQuantity: http.StatusBadRequest,
+	app.ErrorTypeCartClosed:      http.StatusConflict,
+	app.ErrorTypeCartExpired:     http.StatusGone,
+	app.ErrorTypeNotCheckedOut:   http.StatusConflict,
+	app.ErrorTypeOutOfStock:      http.StatusConflict,
+	app.ErrorTypeCouponInvalid:   http.StatusUnprocessableEntity,
+	app.ErrorTypePaymentDeclined: http.StatusPaymentRequired,
+	app.ErrorTypeCheckoutFailed:  http.StatusBadGateway,
+	app.ErrorTypeOrderStatus:     http.StatusConflict,
+	app.ErrorTypeReturnStatus:    http.StatusConflict,
+	app.ErrorTypeRefundExceeded:  http.StatusUnprocessableEntity,
+}
+
+// WriteCartError reports why a cart command was rejected or failed. Errors
+// the workflow did not type are internal server errors.
+func WriteCartError(w http.ResponseWriter, err error) {
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		WriteErrorWithStatus(w, http.StatusNotFound, err)
+		return
+	}
+
+	var appErr *temporalsdk.ApplicationError
+	status, ok := 0, false
+	if errors.As(err, &appErr) {
+		status, ok = cartErrorStatuses[appErr.Type()]
+	}
+	if !ok {
+		WriteError(w, err)
+		return
+	}
+
+	res := ErrorResponse{
+		Message:      appErr.Error(),
+		Type:         appErr.Type(),
+		StartNewCart: appErr.Type() == app.ErrorTypeCartExpired,
+	}
+	var outcome app.CheckoutOutcome
+	if appErr.HasDetails() && appErr.Details(&outcome) == nil {
+		res.Checkout = &outcome
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+func queryCart(workflowID string) (app.CartState, error) {
+	var cart app.CartState
+	response, err := temporal.QueryWorkflow(context.Background(), workflowID, "", "getCart")
+	if err != nil {
+		return cart, err
+	}
+	err = response.Get(&cart)
+	return cart, err
+}
+
+// requireCartStatus rejects signals the cart would ignore in its current
+// status, since a signal cannot report that back itself.
+func requireCartStatus(workflowID string, status app.CartStatus) (app.CartState, error) {
+	cart, err := queryCart(workflowID)
+	if err != nil {
+		return cart, err
+	}
+	if cart.Status == status {
+		return cart, nil
+	}
+	if cart.Status == app.CartExpired {
+		return cart, app.ErrCartExpired
+	}
+	errorType := app.ErrorTypeCartClosed
+	if status == app.CartCheckedOut {
+		errorType = app.ErrorTypeNotCheckedOut
+	}
+	return cart, temporalsdk.NewNonRetryableApplicationError(fmt.Sprintf("cart is %s", cart.Status), errorType, nil)
+}
+
+func queryOrder(orderID string) (app.Order, error) {
+	var order app.Order
+	response, err := temporal.QueryWorkflow(context.Background(), orderID, "", "getOrder")
+	if err != nil {
+		return order, err
+	}
+	err = response.Get(&order)
+	return order, err
+}
+
+// requireOrderStatus rejects signals the order would ignore in its current
+// status.
+func requireOrderStatus(orderID string, status app.OrderStatus) (app.Order, error) {
+	order, err := queryOrder(orderID)
+	if err != nil {
+		return order, err
+	}
+	if order.Status != status {
+		return order, temporalsdk.NewNonRetryableApplicationError(fmt.Sprintf("order is %s", order.Status), app.ErrorTypeOrderStatus, nil)
+	}
+	return order, nil
+}
+
+// queryReturn looks up a return of an order. Returns of other orders are not
+// found.
+func queryReturn(orderID string, returnID string) (app.Return, error) {
+	var ret app.Return
+	response, err := temporal.QueryWorkflow(context.Background(), returnID, "", "getReturn")
+	if err != nil {
+		return ret, err
+	}
+	if err := response.Get(&ret); err != nil {
+		return ret, err
+	}
+	if ret.OrderID != orderID {
+		return app.Return{}, serviceerror.NewNotFound(fmt.Sprintf("order %s has no return %s", orderID, returnID))
+	}
+	return ret, nil
+}
+
+// requireReturnStatus rejects signals the return would ignore in its
+// current status.
+func requireReturnStatus(orderID string, returnID string, status app.ReturnStatus) (app.Return, error) {
+	ret, err := queryReturn(orderID, returnID)
+	if err != nil {
+		return ret, err
+	}
+	if ret.Status != status {
+		return ret, temporalsdk.NewNonRetryableApplicationError(fmt.Sprintf("return is %s", ret.Status), app.ErrorTypeReturnStatus, nil)
+	}
+	return ret, nil
+}
+
+// updateOrder sends an order update and waits for its result. An empty
+// updateID gets a random one.
+func updateOrder(ctx context.Context, orderID string, updateID string, updateName string, arg interface{}, result interface{}) error {
+	handle, err := temporal.UpdateWorkflow(ctx, client.UpdateWorkflowOptions{
+		UpdateID:     updateID,
+		WorkflowID:   orderID,
+		UpdateName:   updateName,
+		Args:         []interface{}{arg},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		return err
+	}
+	return handle.Get(ctx, result)
+}
+
+// updateCart sends a cart update and waits for its result.
+func updateCart(ctx context.Context, workflowID string, updateName string, arg interface{}, result interface{}) error {
+	handle, err := temporal.UpdateWorkflow(ctx, client.UpdateWorkflowOptions{
+		WorkflowID:   workflowID,
+		UpdateName:   updateName,
+		Args:         []interface{}{arg},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		// A cart that has expired has completed, so it can no longer be
+		// updated. Tell the caller why.
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			if cart, qerr := queryCart(workflowID); qerr == nil && cart.Status == app.CartExpired {
+				return app.ErrCartExpired
+			}
+		}
+		return err
+	}
+	return handle.Get(ctx, result)
+}
+
+// currencyParam reads the optional ?currency= query parameter.
+func currencyParam(r *http.Request) (string, error) {
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" {
+		return app.DefaultCurrency, nil
+	}
+	if !app.IsSupportedCurrency(currency) {
+		return "", fmt.Errorf("unsupported currency %q", currency)
+	}
+	return currency, nil
+}
@@ -0,0 +1,441 @@
+package app
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Catalog is the list of products the store sells. The API lists it and
+// checks items against it, and the worker looks up the variants a cart
+// holds. Workflows never read it directly: a cart keeps its own copy of
+// the variants in it, fetched by an activity.
+type Catalog interface {
+	// Products lists every product, ordered by ID.
+	Products(ctx context.Context) ([]Product, error)
+	// Product looks up one product. It reports false if there is no
+	// product with that ID.
+	Product(ctx context.Context, id int) (Product, bool, error)
+	// Variant looks up a variant by SKU. It reports false if no product
+	// has a variant with that SKU.
+	Variant(ctx context.Context, sku string) (ProductVariant, bool, error)
+}
+
+// CatalogEditor is a Catalog that admins can change while the store is
+// running. Deleting a product only hides it, so its ID is never reused and
+// carts that already hold it keep their copy. Every change is written to an
+// audit log along with the admin who made it.
+type CatalogEditor interface {
+	Catalog
+	// Records lists every product, deleted ones included, ordered by ID.
+	Records(ctx context.Context) ([]ProductRecord, error)
+	// Create adds a product with an ID that was never used before.
+	Create(ctx context.Context, admin string, product Product) (ProductRecord, error)
+	// Update replaces a product that has not been deleted, prices and
+	// variants and all, except for stock: only the variants listed in
+	// stocked are set to their Stock, and the others keep what they have.
+	// Variants left out are removed, but stay stocked for the carts that
+	// already hold them.
+	Update(ctx context.Context, admin string, product Product, stocked []string) (ProductRecord, error)
+	// Delete hides a product from the catalog.
+	Delete(ctx context.Context, admin string, id int) (ProductRecord, error)
+	// SetStock sets how many units of a variant are on hand, which is what
+	// the inventory sells from.
+	SetStock(ctx context.Context, admin string, sku string, stock int) (ProductRecord, error)
+	// AuditLog lists the changes to the catalog, oldest first, or only
+	// those to one product if productID is not nil.
+	AuditLog(ctx context.Context, productID *int) ([]AuditEntry, error)
+}
+
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrProductExists   = errors.New("product already exists")
+	ErrSKUTaken        = errors.New("SKU belongs to another product")
+	ErrVariantNotFound = errors.New("variant not found")
+)
+
+// ProductRecord is a product as the catalog stores it.
+type ProductRecord struct {
+	Product
+	UpdatedAt time.Time
+	// DeletedAt is set once the product has been deleted.
+	DeletedAt *time.Time `json:",omitempty"`
+}
+
+// Actions recorded in the audit log.
+const (
+	AuditCreated  = "created"
+	AuditUpdated  = "updated"
+	AuditDeleted  = "deleted"
+	AuditImported = "imported"
+	AuditStocked  = "stocked"
+)
+
+// AuditEntry is one change to the catalog. Before is nil for a product that
+// did not exist yet, and After for one that was deleted.
+type AuditEntry struct {
+	Id        int64
+	At        time.Time
+	Admin     string
+	Action    string
+	ProductId int
+	Before    *Product `json:",omitempty"`
+	After     *Product `json:",omitempty"`
+}
+
+//go:embed catalog/products.yaml
+var defaultCatalogFile []byte
+
+// DefaultCatalog is the catalog that ships with the binaries. It is used
+// when no catalog file or database is configured.
+func DefaultCatalog() *MemoryCatalog {
+	products, err := parseCatalog(defaultCatalogFile, ".yaml")
+	if err != nil {
+		panic(fmt.Sprintf("invalid default catalog: %s", err))
+	}
+	return NewMemoryCatalog(products)
+}
+
+// MemoryCatalog is a Catalog kept in process memory, such as one loaded
+// from a file.
+type MemoryCatalog struct {
+	products []Product
+}
+
+func NewMemoryCatalog(products []Product) *MemoryCatalog {
+	sorted := append([]Product(nil), products...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+	return &MemoryCatalog{products: sorted}
+}
+
+func (m *MemoryCatalog) Products(_ context.Context) ([]Product, error) {
+	return append([]Product(nil), m.products...), nil
+}
+
+func (m *MemoryCatalog) Product(_ context.Context, id int) (Product, bool, error) {
+	for _, product := range m.products {
+		if product.Id == id {
+			return product, true, nil
+		}
+	}
+	return Product{}, false, nil
+}
+
+func (m *MemoryCatalog) Variant(_ context.Context, sku string) (ProductVariant, bool, error) {
+	for _, product := range m.products {
+		if variant, ok := product.Variant(sku); ok {
+			return variant, true, nil
+		}
+	}
+	return ProductVariant{}, false, nil
+}
+
+// LoadCatalogFile reads a catalog from a JSON or YAML file, going by its
+// extension. See catalog/products.yaml for the format.
+func LoadCatalogFile(path string) (*MemoryCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	products, err := parseCatalog(data, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewMemoryCatalog(products), nil
+}
+
+// OpenCatalog picks the catalog the API and worker use. With a database
+// path it opens the SQLite catalog there, and imports the products in file
+// into it if file is also set. With only a file it serves that file, and
+// with neither the default catalog.
+func OpenCatalog(ctx context.Context, file string, database string) (Catalog, error) {
+	var fromFile *MemoryCatalog
+	if file != "" {
+		var err error
+		fromFile, err = LoadCatalogFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if database == "" {
+		if fromFile != nil {
+			return fromFile, nil
+		}
+		return DefaultCatalog(), nil
+	}
+
+	catalog, err := OpenSQLiteCatalog(database)
+	if err != nil {
+		return nil, err
+	}
+	if fromFile != nil {
+		if err := catalog.Import(ctx, "import from "+filepath.Base(file), fromFile.products); err != nil {
+			catalog.Close()
+			return nil, err
+		}
+	}
+	return catalog, nil
+}
+
+type catalogFile struct {
+	Products []catalogFileProduct `json:"products" yaml:"products"`
+}
+
+type catalogFileProduct struct {
+	Id          int                  `json:"id" yaml:"id"`
+	Name        string               `json:"name" yaml:"name"`
+	Description string               `json:"description" yaml:"description"`
+	Image       string               `json:"image" yaml:"image"`
+	Prices      []catalogFilePrice   `json:"prices" yaml:"prices"`
+	Variants    []catalogFileVariant `json:"variants" yaml:"variants"`
+}
+
+type catalogFileVariant struct {
+	SKU     string             `json:"sku" yaml:"sku"`
+	Options []VariantOption    `json:"options" yaml:"options"`
+	Prices  []catalogFilePrice `json:"prices" yaml:"prices"`
+	Image   string             `json:"image" yaml:"image"`
+	Stock   int                `json:"stock" yaml:"stock"`
+}
+
+// catalogFilePrice is a price as written in a catalog file, with the amount
+// in major units like the API's Money.
+type catalogFilePrice struct {
+	Amount   string `json:"amount" yaml:"amount"`
+	Currency string `json:"currency" yaml:"currency"`
+}
+
+func parseCatalog(data []byte, ext string) ([]Product, error) {
+	var file catalogFile
+	var err error
+	switch strings.ToLower(ext) {
+	case ".json":
+		err = json.Unmarshal(data, &file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	default:
+		return nil, fmt.Errorf("unknown catalog format %q, want .json, .yaml or .yml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	products := make([]Product, 0, len(file.Products))
+	for _, entry := range file.Products {
+		prices, err := parseCatalogPrices(entry.Prices)
+		if err != nil {
+			return nil, fmt.Errorf("product %d: %w", entry.Id, err)
+		}
+		product := Product{
+			Id:          entry.Id,
+			Name:        entry.Name,
+			Description: entry.Description,
+			Image:       entry.Image,
+			Prices:      prices,
+			Variants:    make([]Variant, 0, len(entry.Variants)),
+		}
+		for _, variant := range entry.Variants {
+			prices, err := parseCatalogPrices(variant.Prices)
+			if err != nil {
+				return nil, fmt.Errorf("SKU %s: %w", variant.SKU, err)
+			}
+			if len(prices) == 0 {
+				prices = nil
+			}
+			product.Variants = append(product.Variants, Variant{
+				SKU:     variant.SKU,
+				Options: variant.Options,
+				Prices:  prices,
+				Image:   variant.Image,
+				Stock:   variant.Stock,
+			})
+		}
+		products = append(products, product)
+	}
+	if err := validateCatalog(products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func parseCatalogPrices(entries []catalogFilePrice) ([]Money, error) {
+	prices := make([]Money, 0, len(entries))
+	for _, price := range entries {
+		amount, err := ParseMoney(price.Amount, price.Currency)
+		if err != nil {
+			return nil, err
+		}
+		prices = append(prices, amount)
+	}
+	return prices, nil
+}
+
+// validateCatalog checks that every product and variant can be told apart,
+// named and priced.
+func validateCatalog(products []Product) error {
+	seen := make(map[int]bool, len(products))
+	skus := make(map[string]int)
+	for _, product := range products {
+		if seen[product.Id] {
+			return fmt.Errorf("product %d is listed twice", product.Id)
+		}
+		seen[product.Id] = true
+		if err := ValidateProduct(product); err != nil {
+			return err
+		}
+		for _, variant := range product.Variants {
+			if owner, ok := skus[variant.SKU]; ok {
+				return fmt.Errorf("SKU %s belongs to products %d and %d", variant.SKU, owner, product.Id)
+			}
+			skus[variant.SKU] = product.Id
+		}
+	}
+	return nil
+}
+
+// Limits on the text of a product, which shows up in the frontend, on
+// payment statements and in emails.
+const (
+	MaxProductNameLength        = 200
+	MaxProductDescriptionLength = 2000
+	MaxOptionLength             = 50
+)
+
+// skuPattern is what a SKU looks like: upper case letters and digits,
+// maybe split up by dashes, at most 64 of them.
+var skuPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9-]{0,63}$`)
+
+// ValidateProduct checks that a product has a name, an image the frontend
+// can load, if any, and variants that can be told apart by their SKUs and
+// options, each with a positive price in every currency it is sold in.
+func ValidateProduct(product Product) error {
+	if product.Id < 0 {
+		return fmt.Errorf("product ID must not be negative, got %d", product.Id)
+	}
+	if strings.TrimSpace(product.Name) == "" {
+		return fmt.Errorf("product %d has no name", product.Id)
+	}
+	if strings.TrimSpace(product.Name) != product.Name {
+		return fmt.Errorf("product %d has spaces around its name", product.Id)
+	}
+	if n := utf8.RuneCountInString(product.Name); n > MaxProductNameLength {
+		return fmt.Errorf("product %d has a name of %d characters, at most %d are allowed", product.Id, n, MaxProductNameLength)
+	}
+	if n := utf8.RuneCountInString(product.Description); n > MaxProductDescriptionLength {
+		return fmt.Errorf("product %d has a description of %d characters, at most %d are allowed", product.Id, n, MaxProductDescriptionLength)
+	}
+	if err := validateImageURL(product.Image); err != nil {
+		return fmt.Errorf("product %d: %w", product.Id, err)
+	}
+	if err := validatePrices(product.Prices); err != nil {
+		return fmt.Errorf("product %d %w", product.Id, err)
+	}
+	if len(product.Variants) == 0 {
+		return fmt.Errorf("product %d has no variants", product.Id)
+	}
+
+	skus := make(map[string]bool, len(product.Variants))
+	configurations := make(map[string]string, len(product.Variants))
+	for _, variant := range product.Variants {
+		if !skuPattern.MatchString(variant.SKU) {
+			return fmt.Errorf("product %d: SKU must be up to 64 upper case letters, digits and dashes, got %q", product.Id, variant.SKU)
+		}
+		if skus[variant.SKU] {
+			return fmt.Errorf("product %d lists SKU %s twice", product.Id, variant.SKU)
+		}
+		skus[variant.SKU] = true
+
+		if err := validateOptions(variant.Options, product.Variants[0].Options); err != nil {
+			return fmt.Errorf("SKU %s: %w", variant.SKU, err)
+		}
+		configuration := ""
+		for _, option := range variant.Options {
+			configuration += option.Value + "\x00"
+		}
+		if other, ok := configurations[configuration]; ok {
+			return fmt.Errorf("SKUs %s and %s have the same options", other, variant.SKU)
+		}
+		configurations[configuration] = variant.SKU
+
+		if err := validateImageURL(variant.Image); err != nil {
+			return fmt.Errorf("SKU %s: %w", variant.SKU, err)
+		}
+		if err := validatePrices(variant.Prices); err != nil {
+			return fmt.Errorf("SKU %s %w", variant.SKU, err)
+		}
+		if variant.Stock < 0 {
+			return fmt.Errorf("SKU %s has negative stock %d", variant.SKU, variant.Stock)
+		}
+		if len(variant.Prices) == 0 && len(product.Prices) == 0 {
+			return fmt.Errorf("SKU %s has no prices, and neither has product %d", variant.SKU, product.Id)
+		}
+	}
+	return nil
+}
+
+// validatePrices checks a list of prices, which may be empty, and says what
+// is wrong with it as the rest of a sentence.
+func validatePrices(prices []Money) error {
+	currencies := make(map[string]bool, len(prices))
+	for _, price := range prices {
+		if !IsSupportedCurrency(price.Currency) {
+			return fmt.Errorf("is priced in unsupported currency %q", price.Currency)
+		}
+		if currencies[price.Currency] {
+			return fmt.Errorf("has two prices in %s", price.Currency)
+		}
+		currencies[price.Currency] = true
+		if price.Amount <= 0 {
+			return fmt.Errorf("must cost more than nothing in %s", price.Currency)
+		}
+	}
+	return nil
+}
+
+// validateOptions checks that a variant's options are named and valued,
+// and have the same names in the same order as those of the product's
+// first variant.
+func validateOptions(options []VariantOption, first []VariantOption) error {
+	if len(options) != len(first) {
+		return fmt.Errorf("has %d options, but the product's first variant has %d", len(options), len(first))
+	}
+	for i, option := range options {
+		for _, text := range []string{option.Name, option.Value} {
+			if strings.TrimSpace(text) == "" || strings.TrimSpace(text) != text {
+				return fmt.Errorf("option %d must have a name and value without spaces around them", i+1)
+			}
+			if n := utf8.RuneCountInString(text); n > MaxOptionLength {
+				return fmt.Errorf("option %q is %d characters long, at most %d are allowed", text, n, MaxOptionLength)
+			}
+		}
+		if option.Name != first[i].Name {
+			return fmt.Errorf("option %d is %s, but %s on the product's first variant", i+1, option.Name, first[i].Name)
+		}
+	}
+	return nil
+}
+
+// validateImageURL accepts an absolute http or https URL, or no image at
+// all.
+func validateImageURL(image string) error {
+	if image == "" {
+		return nil
+	}
+	u, err := url.Parse(image)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("image must be an http or https URL, got %q", image)
+	}
+	return nil
+}
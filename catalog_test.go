@@ -0,0 +1,515 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withVariants gives a cart built by hand the catalog entries of its items,
+// as the workflow would have looked them up.
+func withVariants(cart CartState) CartState {
+	catalog := DefaultCatalog()
+	for _, item := range cart.Items {
+		if variant, ok, _ := catalog.Variant(context.Background(), item.SKU); ok {
+			cart.Variants = append(cart.Variants, variant)
+		}
+	}
+	return cart
+}
+
+func TestDefaultCatalog(t *testing.T) {
+	ctx := context.Background()
+	catalog := DefaultCatalog()
+	products, err := catalog.Products(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(products))
+	assert.Equal(t, "iPhone 12 Pro", products[0].Name)
+	assert.Equal(t, 3, len(products[0].Variants))
+
+	// Variants without prices of their own cost what the product does
+	variant, ok, err := catalog.Variant(ctx, "IP12PRO-128-GRA")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 0, variant.ProductId)
+	assert.Equal(t, "iPhone 12 Pro 128GB Graphite", variant.Name)
+	assert.Equal(t, products[0].Image, variant.Image)
+	price, ok := variant.Price("USD")
+	assert.True(t, ok)
+	assert.Equal(t, Money{Amount: 99900, Currency: "USD"}, price)
+	price, ok = variant.Price("JPY")
+	assert.True(t, ok)
+	assert.Equal(t, Money{Amount: 117800, Currency: "JPY"}, price)
+
+	variant, ok, err = catalog.Variant(ctx, "IP12PRO-256-GRA")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "iPhone 12 Pro 256GB Graphite", variant.Name)
+	price, _ = variant.Price("USD")
+	assert.Equal(t, Money{Amount: 109900, Currency: "USD"}, price)
+
+	_, ok, err = catalog.Variant(ctx, "IP12PRO-512-GRA")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Every variant is in stock
+	for _, product := range products {
+		for _, variant := range product.Variants {
+			assert.Positive(t, variant.Stock, variant.SKU)
+		}
+	}
+}
+
+func TestProductsIn(t *testing.T) {
+	products, err := DefaultCatalog().Products(context.Background())
+	require.NoError(t, err)
+	priced := ProductsIn(products, "GBP")
+	require.Equal(t, 4, len(priced))
+
+	// A product is shown from the price of its cheapest variant
+	assert.Equal(t, Money{Amount: 104900, Currency: "GBP"}, priced[0].Price)
+	require.Equal(t, 3, len(priced[0].Variants))
+	assert.Equal(t, "IP12PRO-256-GRA", priced[0].Variants[1].SKU)
+	assert.Equal(t, Money{Amount: 114900, Currency: "GBP"}, priced[0].Variants[1].Price)
+	assert.Equal(t, []VariantOption{{Name: "Storage", Value: "256GB"}, {Name: "Colour", Value: "Graphite"}}, priced[0].Variants[1].Options)
+
+	// Only the variants sold in the currency are listed
+	pixel := Product{Id: 9, Name: "Pixel 9", Prices: []Money{{Amount: 79900, Currency: "USD"}}, Variants: []Variant{
+		{SKU: "PIXEL-9-128"},
+		{SKU: "PIXEL-9-256", Prices: []Money{{Amount: 89900, Currency: "USD"}, {Amount: 99900, Currency: "EUR"}}},
+	}}
+	priced = ProductsIn([]Product{pixel}, "EUR")
+	require.Equal(t, 1, len(priced))
+	require.Equal(t, 1, len(priced[0].Variants))
+	assert.Equal(t, "PIXEL-9-256", priced[0].Variants[0].SKU)
+	assert.Empty(t, ProductsIn([]Product{pixel}, "JPY"))
+}
+
+func TestLoadCatalogFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "products.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"products": [
+		{"id": 7, "name": "Pixel 9", "prices": [{"amount": "799.00", "currency": "USD"}], "variants": [
+			{"sku": "PIXEL-9-128", "options": [{"name": "Storage", "value": "128GB"}], "stock": 12},
+			{"sku": "PIXEL-9-256", "options": [{"name": "Storage", "value": "256GB"}], "prices": [{"amount": "899.00", "currency": "USD"}]}
+		]},
+		{"id": 5, "name": "Galaxy S24", "variants": [{"sku": "S24", "prices": [{"amount": "849.99", "currency": "usd"}]}]}
+	]}`), 0o644))
+
+	catalog, err := LoadCatalogFile(path)
+	require.NoError(t, err)
+	products, err := catalog.Products(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, len(products))
+	assert.Equal(t, 5, products[0].Id)
+	assert.Equal(t, []Money{{Amount: 84999, Currency: "USD"}}, products[0].Variants[0].Prices)
+	assert.Equal(t, []Variant{
+		{SKU: "PIXEL-9-128", Options: []VariantOption{{Name: "Storage", Value: "128GB"}}, Stock: 12},
+		{SKU: "PIXEL-9-256", Options: []VariantOption{{Name: "Storage", Value: "256GB"}}, Prices: []Money{{Amount: 89900, Currency: "USD"}}},
+	}, products[1].Variants)
+
+	_, ok, err := catalog.Product(context.Background(), 0)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestLoadCatalogFileRejections(t *testing.T) {
+	for name, contents := range map[string]string{
+		"duplicate.yaml":    "products:\n- {id: 1, name: A, prices: [{amount: '1.00', currency: USD}], variants: [{sku: A}]}\n- {id: 1, name: B, prices: [{amount: '1.00', currency: USD}], variants: [{sku: B}]}\n",
+		"unnamed.yaml":      "products:\n- {id: 1, prices: [{amount: '1.00', currency: USD}], variants: [{sku: A}]}\n",
+		"unpriced.yaml":     "products:\n- {id: 1, name: A, variants: [{sku: A}]}\n",
+		"currency.yaml":     "products:\n- {id: 1, name: A, prices: [{amount: '1.00', currency: CHF}], variants: [{sku: A}]}\n",
+		"amount.yaml":       "products:\n- {id: 1, name: A, prices: [{amount: '1.005', currency: USD}], variants: [{sku: A}]}\n",
+		"free.yaml":         "products:\n- {id: 1, name: A, prices: [{amount: '0', currency: USD}], variants: [{sku: A}]}\n",
+		"products.txt":      "",
+		"malformed.json":    "{",
+		"twoprices.yml":     "products:\n- {id: 1, name: A, prices: [{amount: '1.00', currency: USD}, {amount: '2.00', currency: USD}], variants: [{sku: A}]}\n",
+		"negativeid.yaml":   "products:\n- {id: -1, name: A, prices: [{amount: '1.00', currency: USD}], variants: [{sku: A}]}\n",
+		"novariants.yaml":   "products:\n- {id: 1, name: A, prices: [{amount: '1.00', currency: USD}]}\n",
+		"sharedsku.yaml":    "products:\n- {id: 1, name: A, prices: [{amount: '1.00', currency: USD}], variants: [{sku: A}]}\n- {id: 2, name: B, prices: [{amount: '1.00', currency: USD}], variants: [{sku: A}]}\n",
+		"stock.yaml":        "products:\n- {id: 1, name: A, prices: [{amount: '1.00', currency: USD}], variants: [{sku: A, stock: -1}]}\n",
+		"variantprice.yaml": "products:\n- {id: 1, name: A, prices: [{amount: '1.00', currency: USD}], variants: [{sku: A, prices: [{amount: '1.00', currency: CHF}]}]}\n",
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+		_, err := LoadCatalogFile(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestSQLiteCatalog(t *testing.T) {
+	ctx := context.Background()
+	catalog, err := OpenSQLiteCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+	require.NoError(t, err)
+	defer catalog.Close()
+
+	products, err := DefaultCatalog().Products(ctx)
+	require.NoError(t, err)
+	require.NoError(t, catalog.Import(ctx, "import", products))
+
+	stored, err := catalog.Products(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, products, stored)
+
+	// Importing again leaves edited products alone
+	product := products[1]
+	product.Name = "iPhone 12 (refurbished)"
+	product.Prices = []Money{{Amount: 49900, Currency: "USD"}}
+	product.Variants = append([]Variant(nil), product.Variants...)
+	product.Variants[0].Stock = 3
+	_, err = catalog.Update(ctx, "alice", product, skus(product))
+	require.NoError(t, err)
+	require.NoError(t, catalog.Import(ctx, "import", products))
+
+	got, ok, err := catalog.Product(ctx, product.Id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, product, got)
+
+	_, ok, err = catalog.Product(ctx, 42)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = catalog.Update(ctx, "alice", Product{Id: 42, Name: "Pixel 9", Prices: []Money{{Amount: 79900, Currency: "USD"}}, Variants: []Variant{{SKU: "PIXEL-9"}}}, nil)
+	assert.ErrorIs(t, err, ErrProductNotFound)
+	_, err = catalog.Create(ctx, "alice", Product{Id: 42, Name: "Unpriced", Variants: []Variant{{SKU: "UNPRICED"}}})
+	assert.Error(t, err)
+	_, ok, err = catalog.Product(ctx, 42)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// A SKU belongs to one product only
+	_, err = catalog.Create(ctx, "alice", Product{Id: 42, Name: "Pixel 9", Prices: []Money{{Amount: 79900, Currency: "USD"}}, Variants: []Variant{{SKU: "IP12-64-BLK"}}})
+	assert.ErrorIs(t, err, ErrSKUTaken)
+
+	variant, ok, err := catalog.Variant(ctx, "IP12-128-BLK")
+	require.NoError(t, err)
+	require.True(t, ok)
+	want, _, _ := DefaultCatalog().Variant(ctx, "IP12-128-BLK")
+	want.Name = "iPhone 12 (refurbished) 128GB Black"
+	assert.Equal(t, want, variant)
+	_, ok, err = catalog.Variant(ctx, "PIXEL-9")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestSQLiteCatalogSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	catalog, err := OpenSQLiteCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+	require.NoError(t, err)
+	defer catalog.Close()
+
+	pixel := Product{Id: 9, Name: "Pixel 9", Image: "https://example.com/pixel.jpg", Prices: []Money{{Amount: 79900, Currency: "USD"}}, Variants: []Variant{
+		{SKU: "PIXEL-9-128", Options: []VariantOption{{Name: "Storage", Value: "128GB"}}},
+		{SKU: "PIXEL-9-256", Options: []VariantOption{{Name: "Storage", Value: "256GB"}}, Prices: []Money{{Amount: 89900, Currency: "USD"}}},
+	}}
+	created, err := catalog.Create(ctx, "alice", pixel)
+	require.NoError(t, err)
+	assert.Equal(t, pixel, created.Product)
+	_, err = catalog.Create(ctx, "bob", pixel)
+	assert.ErrorIs(t, err, ErrProductExists)
+
+	deleted, err := catalog.Delete(ctx, "bob", pixel.Id)
+	require.NoError(t, err)
+	require.NotNil(t, deleted.DeletedAt)
+
+	// A deleted product is gone from the store, but keeps its ID and SKUs
+	_, ok, err := catalog.Product(ctx, pixel.Id)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = catalog.Variant(ctx, "PIXEL-9-128")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = catalog.Create(ctx, "bob", Product{Id: 10, Name: "Pixel 9a", Prices: pixel.Prices, Variants: []Variant{{SKU: "PIXEL-9-128"}}})
+	assert.ErrorIs(t, err, ErrSKUTaken)
+	products, err := catalog.Products(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, products)
+	records, err := catalog.Records(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(records))
+	assert.NotNil(t, records[0].DeletedAt)
+	assert.Equal(t, pixel, records[0].Product)
+
+	_, err = catalog.Delete(ctx, "bob", pixel.Id)
+	assert.ErrorIs(t, err, ErrProductNotFound)
+	_, err = catalog.Update(ctx, "bob", pixel, skus(pixel))
+	assert.ErrorIs(t, err, ErrProductNotFound)
+	_, err = catalog.Create(ctx, "bob", pixel)
+	assert.ErrorIs(t, err, ErrProductExists)
+	require.NoError(t, catalog.Import(ctx, "import", []Product{pixel}))
+	_, ok, err = catalog.Product(ctx, pixel.Id)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	entries, err := catalog.AuditLog(ctx, &pixel.Id)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	assert.Equal(t, "alice", entries[0].Admin)
+	assert.Equal(t, AuditCreated, entries[0].Action)
+	assert.Nil(t, entries[0].Before)
+	assert.Equal(t, &pixel, entries[0].After)
+	assert.Equal(t, "bob", entries[1].Admin)
+	assert.Equal(t, AuditDeleted, entries[1].Action)
+	assert.Equal(t, &pixel, entries[1].Before)
+	assert.Nil(t, entries[1].After)
+}
+
+func TestSQLiteCatalogMigratesOldDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.db")
+
+	// A database from before products could be deleted or had variants
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, sqliteCatalogMigrations[0](tx))
+	_, err = tx.Exec(`
+	INSERT INTO products (id, name) VALUES (1, 'iPhone 12'), (2, 'iPhone 12'), (3, '!!');
+	INSERT INTO prices VALUES (1, 'USD', 69900), (2, 'USD', 59900), (3, 'USD', 100)`)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	require.NoError(t, db.Close())
+
+	catalog, err := OpenSQLiteCatalog(path)
+	require.NoError(t, err)
+	defer catalog.Close()
+
+	product, ok, err := catalog.Product(ctx, 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, []Money{{Amount: 69900, Currency: "USD"}}, product.Prices)
+	assert.NoError(t, ValidateProduct(product))
+	// Their made up variants get SKUs from their names and no stock.
+	variant, ok, err := catalog.Variant(ctx, "IPHONE-12")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, ProductVariant{SKU: "IPHONE-12", ProductId: 1, Name: "iPhone 12", Prices: product.Prices}, variant)
+	assert.Equal(t, []Variant{{SKU: "IPHONE-12"}}, product.Variants)
+	for id, want := range map[int]Variant{2: {SKU: "IPHONE-12-2"}, 3: {SKU: "ITEM-3"}} {
+		product, ok, err := catalog.Product(ctx, id)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, []Variant{want}, product.Variants)
+	}
+	_, ok, err = catalog.Variant(ctx, "PRODUCT-1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = catalog.Delete(ctx, "alice", 1)
+	require.NoError(t, err)
+}
+
+func TestValidateProduct(t *testing.T) {
+	valid := Product{Id: 1, Name: "iPhone 12", Image: "https://example.com/iphone.jpg", Prices: []Money{{Amount: 69900, Currency: "USD"}}, Variants: []Variant{
+		{SKU: "IP12-64-BLK", Options: []VariantOption{{Name: "Storage", Value: "64GB"}, {Name: "Colour", Value: "Black"}}},
+		{SKU: "IP12-128-BLK", Options: []VariantOption{{Name: "Storage", Value: "128GB"}, {Name: "Colour", Value: "Black"}}, Prices: []Money{{Amount: 74900, Currency: "USD"}}},
+	}}
+	assert.NoError(t, ValidateProduct(valid))
+
+	for name, edit := range map[string]func(p *Product){
+		"blank name":       func(p *Product) { p.Name = "  " },
+		"padded name":      func(p *Product) { p.Name = " iPhone 12" },
+		"long name":        func(p *Product) { p.Name = strings.Repeat("x", MaxProductNameLength+1) },
+		"long description": func(p *Product) { p.Description = strings.Repeat("x", MaxProductDescriptionLength+1) },
+		"relative image":   func(p *Product) { p.Image = "/images/iphone.jpg" },
+		"script image":     func(p *Product) { p.Image = "javascript:alert(1)" },
+		"hostless image":   func(p *Product) { p.Image = "https:///iphone.jpg" },
+		"no prices":        func(p *Product) { p.Prices = nil },
+		"no variants":      func(p *Product) { p.Variants = nil },
+		"lower case SKU":   func(p *Product) { p.Variants[0].SKU = "ip12-64-blk" },
+		"long SKU":         func(p *Product) { p.Variants[0].SKU = strings.Repeat("X", 65) },
+		"duplicate SKU":    func(p *Product) { p.Variants[1].SKU = "IP12-64-BLK" },
+		"same options":     func(p *Product) { p.Variants[1].Options[0].Value = "64GB" },
+		"missing option":   func(p *Product) { p.Variants[1].Options = p.Variants[1].Options[:1] },
+		"renamed option":   func(p *Product) { p.Variants[1].Options[1].Name = "Color" },
+		"blank option":     func(p *Product) { p.Variants[1].Options[1].Value = "" },
+		"variant image":    func(p *Product) { p.Variants[1].Image = "/images/black.jpg" },
+		"free variant":     func(p *Product) { p.Variants[1].Prices = []Money{{Amount: 0, Currency: "USD"}} },
+		"negative stock":   func(p *Product) { p.Variants[1].Stock = -1 },
+	} {
+		product := valid
+		product.Variants = make([]Variant, len(valid.Variants))
+		for i, variant := range valid.Variants {
+			variant.Options = append([]VariantOption(nil), variant.Options...)
+			product.Variants[i] = variant
+		}
+		edit(&product)
+		assert.Error(t, ValidateProduct(product), name)
+	}
+}
+
+func TestOpenCatalogImportsFileIntoDatabase(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "products.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("products:\n- {id: 9, name: Pixel 9, prices: [{amount: '799.00', currency: USD}], variants: [{sku: PIXEL-9}]}\n"), 0o644))
+
+	catalog, err := OpenCatalog(ctx, file, filepath.Join(dir, "catalog.db"))
+	require.NoError(t, err)
+	defer catalog.(*SQLiteCatalog).Close()
+
+	product, ok, err := catalog.Product(ctx, 9)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "Pixel 9", product.Name)
+	_, ok, err = catalog.Variant(ctx, "PIXEL-9")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestOpenInventoryStocksCatalogFile(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "products.yaml")
+	require.NoError(t, os.WriteFile(file, []byte("products:\n- {id: 9, name: Pixel 9, prices: [{amount: '799.00', currency: USD}], variants: [{sku: PIXEL-9, options: [{name: Size, value: Regular}], stock: 3}, {sku: PIXEL-9-XL, options: [{name: Size, value: XL}]}]}\n"), 0o644))
+
+	catalog, err := OpenCatalog(ctx, file, "")
+	require.NoError(t, err)
+	inventory, err := OpenInventory(ctx, catalog)
+	require.NoError(t, err)
+
+	available, err := inventory.Available(ctx, "PIXEL-9")
+	require.NoError(t, err)
+	assert.Equal(t, 3, available)
+	assert.ErrorIs(t, inventory.Reserve(ctx, "cart-1", "PIXEL-9", 4), ErrOutOfStock)
+	assert.NoError(t, inventory.Reserve(ctx, "cart-1", "PIXEL-9", 3))
+	assert.ErrorIs(t, inventory.Reserve(ctx, "cart-1", "PIXEL-9-XL", 1), ErrOutOfStock)
+}
+
+func TestSQLiteInventory(t *testing.T) {
+	ctx := context.Background()
+	catalog, err := OpenSQLiteCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+	require.NoError(t, err)
+	defer catalog.Close()
+	pixel := Product{Id: 9, Name: "Pixel 9", Prices: []Money{{Amount: 79900, Currency: "USD"}}, Variants: []Variant{{SKU: "PIXEL-9", Stock: 5}}}
+	_, err = catalog.Create(ctx, "alice", pixel)
+	require.NoError(t, err)
+
+	store, err := OpenInventory(ctx, catalog)
+	require.NoError(t, err)
+	inventory := store.(*SQLiteInventory)
+
+	// Products added to the catalog are on sale straight away
+	require.NoError(t, inventory.Reserve(ctx, "cart-1", "PIXEL-9", 2))
+	require.NoError(t, inventory.Reserve(ctx, "cart-1", "PIXEL-9", 5))
+	assert.ErrorIs(t, inventory.Reserve(ctx, "cart-2", "PIXEL-9", 1), ErrOutOfStock)
+	assert.ErrorIs(t, inventory.Reserve(ctx, "cart-2", "PIXEL-10", 1), ErrOutOfStock)
+	assert.ErrorIs(t, inventory.Reserve(ctx, "cart-2", "PIXEL-9", -1), ErrInvalidQuantity)
+	require.NoError(t, inventory.Reserve(ctx, "cart-1", "PIXEL-9", 1))
+	require.NoError(t, inventory.Reserve(ctx, "cart-2", "PIXEL-9", 2))
+	available, err := inventory.Available(ctx, "PIXEL-9")
+	require.NoError(t, err)
+	assert.Equal(t, 2, available)
+
+	// Holds survive the product being edited
+	pixel.Name = "Pixel 9 (2024)"
+	_, err = catalog.Update(ctx, "alice", pixel, nil)
+	require.NoError(t, err)
+	require.NoError(t, inventory.Release(ctx, "cart-2"))
+	require.NoError(t, inventory.Commit(ctx, "cart-1"))
+	available, err = inventory.Available(ctx, "PIXEL-9")
+	require.NoError(t, err)
+	assert.Equal(t, 4, available)
+	product, _, err := catalog.Product(ctx, 9)
+	require.NoError(t, err)
+	assert.Equal(t, 4, product.Variants[0].Stock)
+
+	// A return is only restocked once
+	require.NoError(t, inventory.Restock(ctx, "return-1", "PIXEL-9", 1))
+	require.NoError(t, inventory.Restock(ctx, "return-1", "PIXEL-9", 1))
+	assert.ErrorIs(t, inventory.Restock(ctx, "return-2", "PIXEL-9", 0), ErrInvalidQuantity)
+	available, err = inventory.Available(ctx, "PIXEL-9")
+	require.NoError(t, err)
+	assert.Equal(t, 5, available)
+	available, err = inventory.Available(ctx, "PIXEL-10")
+	require.NoError(t, err)
+	assert.Equal(t, 0, available)
+}
+
+func TestSQLiteCatalogSetStock(t *testing.T) {
+	ctx := context.Background()
+	catalog, err := OpenSQLiteCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+	require.NoError(t, err)
+	defer catalog.Close()
+	pixel := Product{Id: 9, Name: "Pixel 9", Prices: []Money{{Amount: 79900, Currency: "USD"}}, Variants: []Variant{{SKU: "PIXEL-9"}}}
+	_, err = catalog.Create(ctx, "alice", pixel)
+	require.NoError(t, err)
+
+	// A product created without stock can be stocked later
+	inventory := catalog.Inventory()
+	assert.ErrorIs(t, inventory.Reserve(ctx, "cart-1", "PIXEL-9", 1), ErrOutOfStock)
+	record, err := catalog.SetStock(ctx, "bob", "PIXEL-9", 7)
+	require.NoError(t, err)
+	assert.Equal(t, 7, record.Variants[0].Stock)
+	require.NoError(t, inventory.Reserve(ctx, "cart-1", "PIXEL-9", 7))
+
+	entries, err := catalog.AuditLog(ctx, &pixel.Id)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	assert.Equal(t, "bob", entries[1].Admin)
+	assert.Equal(t, AuditStocked, entries[1].Action)
+	assert.Equal(t, 0, entries[1].Before.Variants[0].Stock)
+	assert.Equal(t, 7, entries[1].After.Variants[0].Stock)
+
+	_, err = catalog.SetStock(ctx, "bob", "PIXEL-9", -1)
+	assert.ErrorIs(t, err, ErrInvalidQuantity)
+	_, err = catalog.SetStock(ctx, "bob", "PIXEL-10", 1)
+	assert.ErrorIs(t, err, ErrVariantNotFound)
+	_, err = catalog.Delete(ctx, "alice", pixel.Id)
+	require.NoError(t, err)
+	_, err = catalog.SetStock(ctx, "bob", "PIXEL-9", 1)
+	assert.ErrorIs(t, err, ErrVariantNotFound)
+}
+
+func TestSQLiteCatalogUpdateKeepsStock(t *testing.T) {
+	ctx := context.Background()
+	catalog, err := OpenSQLiteCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+	require.NoError(t, err)
+	defer catalog.Close()
+	pixel := Product{Id: 9, Name: "Pixel 9", Prices: []Money{{Amount: 79900, Currency: "USD"}}, Variants: []Variant{
+		{SKU: "PIXEL-9-128", Options: []VariantOption{{Name: "Storage", Value: "128GB"}}, Stock: 5},
+		{SKU: "PIXEL-9-256", Options: []VariantOption{{Name: "Storage", Value: "256GB"}}, Stock: 5},
+	}}
+	_, err = catalog.Create(ctx, "alice", pixel)
+	require.NoError(t, err)
+	inventory := catalog.Inventory()
+	require.NoError(t, inventory.Reserve(ctx, "cart-1", "PIXEL-9-128", 2))
+	require.NoError(t, inventory.Commit(ctx, "cart-1"))
+	require.NoError(t, inventory.Reserve(ctx, "cart-2", "PIXEL-9-256", 1))
+
+	// An edit made from a copy read before the sale leaves the sale alone,
+	// and a variant left out is only removed
+	edited := pixel
+	edited.Name = "Pixel 9 (2024)"
+	edited.Variants = []Variant{pixel.Variants[0], {SKU: "PIXEL-9-512", Options: []VariantOption{{Name: "Storage", Value: "512GB"}}, Stock: 4}}
+	record, err := catalog.Update(ctx, "alice", edited, []string{"PIXEL-9-512"})
+	require.NoError(t, err)
+	assert.Equal(t, []Variant{
+		{SKU: "PIXEL-9-128", Options: []VariantOption{{Name: "Storage", Value: "128GB"}}, Stock: 3},
+		{SKU: "PIXEL-9-512", Options: []VariantOption{{Name: "Storage", Value: "512GB"}}, Stock: 4},
+	}, record.Variants)
+	entries, err := catalog.AuditLog(ctx, &pixel.Id)
+	require.NoError(t, err)
+	assert.Equal(t, &record.Product, entries[len(entries)-1].After)
+
+	// The cart holding the removed variant can still check out, but it can
+	// no longer be added to carts, and its SKU stays taken
+	require.NoError(t, inventory.Reserve(ctx, "cart-2", "PIXEL-9-256", 1))
+	require.NoError(t, inventory.Commit(ctx, "cart-2"))
+	_, ok, err := catalog.Variant(ctx, "PIXEL-9-256")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, err = catalog.Create(ctx, "alice", Product{Id: 10, Name: "Pixel 10", Prices: []Money{{Amount: 79900, Currency: "USD"}}, Variants: []Variant{{SKU: "PIXEL-9-256"}}})
+	assert.ErrorIs(t, err, ErrSKUTaken)
+
+	// Putting it back brings back its stock
+	record, err = catalog.Update(ctx, "alice", pixel, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, record.Variants[0].Stock)
+	assert.Equal(t, 4, record.Variants[1].Stock)
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// Checkout steps, in the order the saga runs them.
+const (
+	StepReserveInventory = "reserve_inventory"
+	StepAuthorizePayment = "authorize_payment"
+	StepCreateOrder      = "create_order"
+	StepSendConfirmation = "send_confirmation"
+)
+
+// CheckoutOutcome records how the last checkout attempt went. When a step
+// fails, FailedStep, ErrorType and Error say why and Compensations lists the
+// steps that were undone, most recent first.
+type CheckoutOutcome struct {
+	Attempt       int
+	Succeeded     bool
+	OrderID       string
+	FailedStep    string
+	ErrorType     string
+	Error         string
+	Compensations []string
+}
+
+// saga remembers how to undo each completed step so a failed checkout can be
+// rolled back in reverse order.
+type saga struct {
+	steps         []string
+	compensations []func(ctx workflow.Context) error
+}
+
+func (s *saga) addCompensation(step string, compensation func(ctx workflow.Context) error) {
+	s.steps = append(s.steps, step)
+	s.compensations = append(s.compensations, compensation)
+}
+
+// compensate undoes completed steps, most recent first. It keeps going when
+// a compensation fails and runs even if the workflow is being cancelled.
+func (s *saga) compensate(ctx workflow.Context) []string {
+	logger := workflow.GetLogger(ctx)
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+
+	compensated := make([]string, 0, len(s.steps))
+	for i := len(s.compensations) - 1; i >= 0; i-- {
+		err := s.compensations[i](ctx)
+		if err != nil {
+			logger.Error("Compensation failed", "Step", s.steps[i], "Error", err)
+			continue
+		}
+		compensated = append(compensated, s.steps[i])
+	}
+	return compensated
+}
+
+// runCheckout places an order for the cart as a saga: reserve inventory,
+// authorize payment, create the order and send a confirmation. If any step
+// fails, the steps before it are compensated and the cart stays open. The
+// outcome is recorded in state.Checkout either way, and a failure is returned
+// as an application error of type outcome.ErrorType with the outcome as its
+// details.
+func runCheckout(ctx workflow.Context, state *CartState) error {
+	logger := workflow.GetLogger(ctx)
+	cartID := workflow.GetInfo(ctx).WorkflowExecution.ID
+
+	// Give up on a step after a few attempts rather than leave the shopper
+	// waiting on a checkout that will never finish.
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 5,
+		},
+	})
+
+	state.CheckoutAttempt++
+	outcome := CheckoutOutcome{Attempt: state.CheckoutAttempt}
+	var compensations saga
+	var a *Activities
+
+	fail := func(step string, err error) error {
+		logger.Error("Checkout failed", "Step", step, "Error", err)
+		outcome.FailedStep = step
+		outcome.ErrorType = errorType(err)
+		outcome.Error = updateError(err).Error()
+		outcome.Compensations = compensations.compensate(ctx)
+		state.Checkout = outcome
+		return temporal.NewNonRetryableApplicationError(outcome.Error, outcome.ErrorType, nil, outcome)
+	}
+
+	// Re-reserve every line in case a hold was lost since it was added.
+	for _, item := range state.Items {
+		err := workflow.ExecuteActivity(ctx, a.ReserveInventory, cartID, item.SKU, item.Quantity).Get(ctx, nil)
+		if err != nil {
+			return fail(StepReserveInventory, err)
+		}
+	}
+	// The cart stays open after a failed checkout, so undoing this step
+	// puts back the holds its items had rather than releasing them.
+	held := append([]CartItem(nil), state.Items...)
+	compensations.addCompensation(StepReserveInventory, func(ctx workflow.Context) error {
+		for _, item := range held {
+			err := workflow.ExecuteActivity(ctx, a.ReserveInventory, cartID, item.SKU, item.Quantity).Get(ctx, nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	var payment Payment
+	key := paymentIdempotencyKey(ctx, state.CheckoutAttempt, "authorize")
+	err := workflow.ExecuteActivity(ctx, a.AuthorizePayment, *state, key).Get(ctx, &payment)
+	if err != nil {
+		return fail(StepAuthorizePayment, err)
+	}
+	state.PaymentIntentID = payment.ID
+	state.PaymentStatus = payment.Status
+	compensations.addCompensation(StepAuthorizePayment, func(ctx workflow.Context) error {
+		key := paymentIdempotencyKey(ctx, state.CheckoutAttempt, "cancel")
+		err := workflow.ExecuteActivity(ctx, a.CancelPayment, state.PaymentIntentID, key).Get(ctx, &payment)
+		if err == nil {
+			state.PaymentStatus = payment.Status
+		}
+		return err
+	})
+
+	order := Order{
+		ID:              fmt.Sprintf("ORDER-%s-%d", cartID, state.CheckoutAttempt),
+		CartID:          cartID,
+		Email:           state.Email,
+		Locale:          state.Locale,
+		Items:           state.Items,
+		Pricing:         state.Pricing,
+		PaymentID:       payment.ID,
+		PaymentStatus:   payment.Status,
+		Status:          OrderPlaced,
+		PlacedAt:        workflow.Now(ctx),
+		ShippingAddress: state.ShippingAddress,
+	}
+	state.Order = order
+	compensations.addCompensation(StepCreateOrder, func(ctx workflow.Context) error {
+		state.Order.Status = OrderCancelled
+		return nil
+	})
+
+	err = workflow.ExecuteActivity(ctx, a.SendOrderConfirmationEmail, order).Get(ctx, nil)
+	if err != nil {
+		return fail(StepSendConfirmation, err)
+	}
+
+	outcome.Succeeded = true
+	outcome.OrderID = order.ID
+	state.Checkout = outcome
+	return nil
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CartConfig is the part of a cart's behaviour that differs between
+// deployments. It is passed to CartWorkflow alongside the CartState and
+// carried over when the cart continues as new, so changing it only affects
+// carts created afterwards.
+type CartConfig struct {
+	// A cart left alone for AbandonedCartTimeout counts as abandoned, which
+	// starts the Reminders.
+	AbandonedCartTimeout time.Duration
+	Reminders            []ReminderStep
+	// A cart nobody has touched for TTL expires: its units go back on sale
+	// and the workflow completes.
+	TTL time.Duration
+	// A cart continues as new once its history reaches either limit, or when
+	// the server suggests it.
+	MaxHistoryEvents int
+	MaxHistoryBytes  int
+}
+
+// DefaultCartConfig returns the settings a cart gets unless the environment
+// says otherwise, see CartConfigFromEnv. Carts never read it themselves: the
+// API server and the starter fill in a complete CartConfig before they start
+// one, so that every worker replays a cart with the same settings.
+func DefaultCartConfig() CartConfig {
+	return CartConfig{
+		// Short timeout to consider shopping cart abandoned for development purposes.
+		AbandonedCartTimeout: 10 * time.Second,
+		Reminders:            append([]ReminderStep(nil), AbandonedCartReminders...),
+		TTL:                  7 * 24 * time.Hour,
+		MaxHistoryEvents:     2000,
+		MaxHistoryBytes:      2 << 20,
+	}
+}
+
+// Validate reports the first setting that was left unset. A nil Reminders
+// counts as unset, while an empty one turns the reminders off.
+func (c CartConfig) Validate() error {
+	switch {
+	case c.AbandonedCartTimeout <= 0:
+		return errors.New("AbandonedCartTimeout is not set")
+	case c.Reminders == nil:
+		return errors.New("Reminders is not set")
+	case c.TTL <= 0:
+		return errors.New("TTL is not set")
+	case c.MaxHistoryEvents <= 0:
+		return errors.New("MaxHistoryEvents is not set")
+	case c.MaxHistoryBytes <= 0:
+		return errors.New("MaxHistoryBytes is not set")
+	}
+	return nil
+}
+
+// CartConfigFromEnv overrides the defaults with any of these environment
+// variables that are set:
+//
+//	CART_ABANDONED_TIMEOUT   duration, e.g. 1h
+//	CART_REMINDERS           schedule, see ParseReminders
+//	CART_TTL                 duration, e.g. 168h
+//	CART_MAX_HISTORY_EVENTS  number of events
+//	CART_MAX_HISTORY_BYTES   number of bytes
+func CartConfigFromEnv(defaults CartConfig) (CartConfig, error) {
+	config := defaults
+	var err error
+
+	if v := os.Getenv("CART_ABANDONED_TIMEOUT"); v != "" {
+		if config.AbandonedCartTimeout, err = parsePositiveDuration(v); err != nil {
+			return CartConfig{}, fmt.Errorf("CART_ABANDONED_TIMEOUT: %w", err)
+		}
+	}
+	if v, ok := os.LookupEnv("CART_REMINDERS"); ok {
+		if config.Reminders, err = ParseReminders(v); err != nil {
+			return CartConfig{}, fmt.Errorf("CART_REMINDERS: %w", err)
+		}
+	}
+	if v := os.Getenv("CART_TTL"); v != "" {
+		if config.TTL, err = parsePositiveDuration(v); err != nil {
+			return CartConfig{}, fmt.Errorf("CART_TTL: %w", err)
+		}
+	}
+	if v := os.Getenv("CART_MAX_HISTORY_EVENTS"); v != "" {
+		if config.MaxHistoryEvents, err = parsePositiveInt(v); err != nil {
+			return CartConfig{}, fmt.Errorf("CART_MAX_HISTORY_EVENTS: %w", err)
+		}
+	}
+	if v := os.Getenv("CART_MAX_HISTORY_BYTES"); v != "" {
+		if config.MaxHistoryBytes, err = parsePositiveInt(v); err != nil {
+			return CartConfig{}, fmt.Errorf("CART_MAX_HISTORY_BYTES: %w", err)
+		}
+	}
+
+	return config, nil
+}
+
+// ParseReminders reads a reminder schedule written as comma separated
+// stage=after steps, with an optional coupon code after a colon, e.g.
+// "reminder=0s,discount=23h:SAVE10,final=71h". After is measured from the
+// moment the cart counts as abandoned, and must not go down from one step to
+// the next. An empty schedule sends no reminders.
+func ParseReminders(schedule string) ([]ReminderStep, error) {
+	steps := make([]ReminderStep, 0)
+	if strings.TrimSpace(schedule) == "" {
+		return steps, nil
+	}
+
+	for _, field := range strings.Split(schedule, ",") {
+		stage, rest, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("step %q is not stage=after", field)
+		}
+
+		step := ReminderStep{Stage: ReminderStage(stage)}
+		switch step.Stage {
+		case ReminderFirst, ReminderDiscount, ReminderFinal:
+		default:
+			return nil, fmt.Errorf("unknown reminder stage %q", stage)
+		}
+
+		after, coupon, _ := strings.Cut(rest, ":")
+		d, err := time.ParseDuration(after)
+		if err != nil {
+			return nil, err
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("step %q is scheduled before the cart is abandoned", field)
+		}
+		if len(steps) > 0 && d < steps[len(steps)-1].After {
+			return nil, fmt.Errorf("step %q is scheduled before the one it follows", field)
+		}
+		step.After = d
+		step.CouponCode = coupon
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+func parsePositiveDuration(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive, got %s", v)
+	}
+	return d, nil
+}
+
+func parsePositiveInt(v string) (int, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("must be positive, got %s", v)
+	}
+	return n, nil
+}
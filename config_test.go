@@ -0,0 +1,50 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReminders(t *testing.T) {
+	steps, err := ParseReminders("reminder=0s, discount=23h:SAVE10, final=71h")
+	require.NoError(t, err)
+	assert.Equal(t, AbandonedCartReminders, steps)
+
+	steps, err = ParseReminders("")
+	require.NoError(t, err)
+	assert.NotNil(t, steps)
+	assert.Empty(t, steps)
+
+	for _, schedule := range []string{
+		"reminder",
+		"nag=1h",
+		"reminder=soon",
+		"reminder=-1h",
+		"reminder=2h,final=1h",
+	} {
+		_, err := ParseReminders(schedule)
+		assert.Error(t, err, schedule)
+	}
+}
+
+func TestCartConfigFromEnv(t *testing.T) {
+	t.Setenv("CART_ABANDONED_TIMEOUT", "1h")
+	t.Setenv("CART_REMINDERS", "")
+	t.Setenv("CART_MAX_HISTORY_EVENTS", "500")
+
+	config, err := CartConfigFromEnv(DefaultCartConfig())
+	require.NoError(t, err)
+	require.NoError(t, config.Validate())
+	assert.Equal(t, time.Hour, config.AbandonedCartTimeout)
+	assert.Empty(t, config.Reminders)
+	assert.Equal(t, DefaultCartConfig().TTL, config.TTL)
+	assert.Equal(t, 500, config.MaxHistoryEvents)
+	assert.Equal(t, DefaultCartConfig().MaxHistoryBytes, config.MaxHistoryBytes)
+
+	t.Setenv("CART_TTL", "0s")
+	_, err = CartConfigFromEnv(DefaultCartConfig())
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EmailSender delivers the emails sent by Activities.
+type EmailSender interface {
+	Send(ctx context.Context, to string, email Email) error
+}
+
+// SMTPSender is an EmailSender that hands messages to an SMTP server, using
+// STARTTLS if the server offers it.
+type SMTPSender struct {
+	// Addr is the server's host:port.
+	Addr string
+	// Username and Password are only sent if Username is set.
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(_ context.Context, to string, email Email) error {
+	message, err := buildEmailMessage(s.From, to, email, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	return smtp.SendMail(s.Addr, auth, s.From, []string{to}, message)
+}
+
+// FileEmailSender is an EmailSender that writes every message to its own .eml
+// file in Dir instead of sending it, so the whole cart flow can run offline
+// and tests can look at what would have been sent.
+type FileEmailSender struct {
+	Dir  string
+	From string
+}
+
+func (s *FileEmailSender) Send(_ context.Context, to string, email Email) error {
+	now := time.Now()
+	message, err := buildEmailMessage(s.From, to, email, now)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that whoever watches Dir never
+	// sees half a message.
+	f, err := os.CreateTemp(s.Dir, ".email-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(message); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	id, err := randomHex(4)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000Z"), id)
+	return os.Rename(f.Name(), filepath.Join(s.Dir, name))
+}
+
+// SentEmails lists the .eml files in Dir, oldest first.
+func (s *FileEmailSender) SentEmails() ([]string, error) {
+	// The names start with the time they were written, so sorting by name
+	// sorts by time.
+	return filepath.Glob(filepath.Join(s.Dir, "*.eml"))
+}
+
+// buildEmailMessage formats an email as a MIME message with a plain text
+// part and, if there is one, an HTML alternative.
+func buildEmailMessage(from string, to string, email Email, date time.Time) ([]byte, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if _, d, ok := strings.Cut(from, "@"); ok {
+		domain = d
+	}
+
+	var buf bytes.Buffer
+	parts := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", id, domain)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+
+	bodies := []struct{ contentType, body string }{{"text/plain", email.Text}}
+	if email.HTML != "" {
+		bodies = append(bodies, struct{ contentType, body string }{"text/html", email.HTML})
+	}
+	for _, b := range bodies {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {b.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err := qp.Write([]byte(b.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
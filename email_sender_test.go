@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileEmailSender(t *testing.T) {
+	sender := &FileEmailSender{Dir: t.TempDir(), From: "noreply@shop.example.com"}
+	a := &Activities{Emails: sender}
+
+	order := Order{ID: "ORDER-1", CartID: "CART-1", Email: "test@temporal.io", Locale: "de"}
+	require.NoError(t, a.SendOrderConfirmationEmail(context.Background(), order))
+	require.NoError(t, a.SendOrderConfirmationEmail(context.Background(), Order{ID: "ORDER-2"}))
+
+	// No address, no email
+	files, err := sender.SentEmails()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	msg, err := mail.ReadMessage(f)
+	require.NoError(t, err)
+
+	assert.Equal(t, "noreply@shop.example.com", msg.Header.Get("From"))
+	assert.Equal(t, "test@temporal.io", msg.Header.Get("To"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Ihre Bestellung ORDER-1 ist bestätigt", subject)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var types []string
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		// NextPart undoes the quoted-printable encoding
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		types = append(types, part.Header.Get("Content-Type"))
+		bodies = append(bodies, string(body))
+	}
+	assert.Equal(t, []string{"text/plain; charset=utf-8", "text/html; charset=utf-8"}, types)
+	assert.Contains(t, bodies[0], "http://localhost:8080/#/orders/ORDER-1")
+	assert.Contains(t, bodies[1], `<html lang="de">`)
+}
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Names of the email templates in templates/. Each has a .txt template, which
+// also defines the subject, and a .html template rendered inside layout.html.
+// Both list the cart's lines and totals.
+const (
+	EmailAbandonedCart        = "abandoned_cart"
+	EmailOrderConfirmation    = "order_confirmation"
+	EmailShippingNotification = "shipping_notification"
+	EmailOrderCancellation    = "order_cancellation"
+)
+
+// DefaultStoreURL is where the frontend runs in development. Links in emails
+// point there unless the worker is told otherwise.
+const DefaultStoreURL = "http://localhost:8080"
+
+// Email is a rendered message, ready to send.
+type Email struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// EmailData is what email templates can refer to.
+type EmailData struct {
+	Locale string
+	CartID string
+	// CartURL opens this cart in the frontend.
+	CartURL string
+	Lines   []EmailLine
+	Pricing Pricing
+
+	// Set for abandoned cart reminders.
+	Stage      ReminderStage
+	CouponCode string
+
+	// Set for order emails. OrderURL opens the order in the frontend.
+	OrderID         string
+	OrderURL        string
+	ShippingAddress ShippingAddress
+	Shipment        Shipment
+	// Refunded is set for cancelled orders that had already been paid for.
+	Refunded Money
+}
+
+// EmailLine is a line of the cart as an email shows it.
+type EmailLine struct {
+	PricedLine
+	// Thumbnail is the line's Image scaled down for emails, or empty if it
+	// has none.
+	Thumbnail string
+}
+
+// thumbnailWidth is twice the width images are shown at, for sharp images
+// on high density screens.
+const thumbnailWidth = "160"
+
+func emailLines(lines []PricedLine) []EmailLine {
+	emailLines := make([]EmailLine, 0, len(lines))
+	for _, line := range lines {
+		emailLines = append(emailLines, EmailLine{PricedLine: line, Thumbnail: thumbnailURL(line.Image)})
+	}
+	return emailLines
+}
+
+// thumbnailURL asks the image host for a copy of image thumbnailWidth pixels
+// wide, keeping any query the image already has.
+func thumbnailURL(image string) string {
+	if image == "" {
+		return ""
+	}
+	u, err := url.Parse(image)
+	if err != nil {
+		return ""
+	}
+	query := u.Query()
+	query.Set("w", thumbnailWidth)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+//go:embed templates
+var templateFS embed.FS
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var emailTemplates = mustParseEmailTemplates(EmailAbandonedCart, EmailOrderConfirmation, EmailShippingNotification, EmailOrderCancellation)
+
+func mustParseEmailTemplates(names ...string) map[string]emailTemplate {
+	// t and money are replaced by those of the email's locale when rendering.
+	funcs := map[string]interface{}{"t": translator(DefaultLocale), "money": moneyFormatter(DefaultLocale)}
+
+	templates := make(map[string]emailTemplate, len(names))
+	for _, name := range names {
+		templates[name] = emailTemplate{
+			text: texttemplate.Must(texttemplate.New(name).Funcs(funcs).ParseFS(templateFS, "templates/lines.txt", "templates/"+name+".txt")),
+			html: htmltemplate.Must(htmltemplate.New(name).Funcs(funcs).ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")),
+		}
+	}
+	return templates
+}
+
+// RenderEmail writes the named email in data.Locale, or DefaultLocale if
+// that is not supported.
+func RenderEmail(name string, data EmailData) (Email, error) {
+	tmpl, ok := emailTemplates[name]
+	if !ok {
+		return Email{}, fmt.Errorf("unknown email template %q", name)
+	}
+	if !IsSupportedLocale(data.Locale) {
+		data.Locale = DefaultLocale
+	}
+	funcs := map[string]interface{}{"t": translator(data.Locale), "money": moneyFormatter(data.Locale)}
+
+	text, err := tmpl.text.Clone()
+	if err != nil {
+		return Email{}, err
+	}
+	text.Funcs(funcs)
+	var subject, body strings.Builder
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Email{}, err
+	}
+	if err := text.ExecuteTemplate(&body, name+".txt", data); err != nil {
+		return Email{}, err
+	}
+
+	html, err := tmpl.html.Clone()
+	if err != nil {
+		return Email{}, err
+	}
+	html.Funcs(funcs)
+	var htmlBody strings.Builder
+	if err := html.ExecuteTemplate(&htmlBody, "layout.html", data); err != nil {
+		return Email{}, err
+	}
+
+	return Email{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    body.String(),
+		HTML:    htmlBody.String(),
+	}, nil
+}
+
+// CartURL links to a cart in the frontend, which picks it up from the
+// workflow parameter.
+func CartURL(storeURL string, cartID string) string {
+	return strings.TrimSuffix(storeURL, "/") + "/#/cart?workflow=" + url.QueryEscape(cartID)
+}
+
+// OrderURL links to an order in the frontend, which shows what the API
+// server's /orders/{orderID} returns.
+func OrderURL(storeURL string, orderID string) string {
+	return strings.TrimSuffix(storeURL, "/") + "/#/orders/" + url.PathEscape(orderID)
+}
+
+// AbandonedCartEmail is the data for one reminder of the abandoned cart
+// campaign.
+func AbandonedCartEmail(reminder AbandonedCartReminder, storeURL string) EmailData {
+	return EmailData{
+		Locale:     reminder.Locale,
+		CartID:     reminder.CartID,
+		CartURL:    CartURL(storeURL, reminder.CartID),
+		Lines:      emailLines(reminder.Pricing.Lines),
+		Pricing:    reminder.Pricing,
+		Stage:      reminder.Stage,
+		CouponCode: reminder.CouponCode,
+	}
+}
+
+// OrderConfirmationEmail is the data for the email confirming an order.
+func OrderConfirmationEmail(order Order, storeURL string) EmailData {
+	return EmailData{
+		Locale:          order.Locale,
+		CartID:          order.CartID,
+		CartURL:         CartURL(storeURL, order.CartID),
+		Lines:           emailLines(order.Pricing.Lines),
+		Pricing:         order.Pricing,
+		OrderID:         order.ID,
+		OrderURL:        OrderURL(storeURL, order.ID),
+		ShippingAddress: order.ShippingAddress,
+	}
+}
+
+// ShippingNotificationEmail is the data for the email telling the customer
+// their order has shipped.
+func ShippingNotificationEmail(order Order, storeURL string) EmailData {
+	data := OrderConfirmationEmail(order, storeURL)
+	data.Shipment = order.Shipment
+	return data
+}
+
+// OrderCancellationEmail is the data for the email telling the customer
+// their order was cancelled.
+func OrderCancellationEmail(order Order, storeURL string) EmailData {
+	data := OrderConfirmationEmail(order, storeURL)
+	data.Refunded = order.Refunded
+	return data
+}
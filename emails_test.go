@@ -0,0 +1,141 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderAbandonedCartEmail(t *testing.T) {
+	cart := withVariants(CartState{Currency: "USD", Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}})
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+
+	reminder := AbandonedCartReminder{
+		CartID:     "CART-1",
+		Email:      "test@temporal.io",
+		Stage:      ReminderDiscount,
+		CouponCode: "SAVE10",
+		Pricing:    pricing,
+	}
+	email, err := RenderEmail(EmailAbandonedCart, AbandonedCartEmail(reminder, "https://shop.example.com/"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "A little something to help you check out", email.Subject)
+	assert.Contains(t, email.Text, "SAVE10")
+	assert.Contains(t, email.Text, "2 x iPhone 12 64GB Black  $1,398.00")
+	assert.Contains(t, email.Text, "Total: $1,499.36")
+	assert.Contains(t, email.Text, "https://shop.example.com/#/cart?workflow=CART-1")
+
+	assert.Contains(t, email.HTML, `<html lang="en">`)
+	assert.Contains(t, email.HTML, `src="https://images.unsplash.com/photo-1611472173362-3f53dbd65d80?w=160"`)
+	assert.Contains(t, email.HTML, `href="https://shop.example.com/#/cart?workflow=CART-1"`)
+	assert.Contains(t, email.HTML, "$1,499.36")
+}
+
+func TestRenderEmailLocales(t *testing.T) {
+	order := Order{ID: "ORDER-<1>", CartID: "CART-1", Locale: "de"}
+	email, err := RenderEmail(EmailOrderConfirmation, OrderConfirmationEmail(order, DefaultStoreURL))
+	require.NoError(t, err)
+	assert.Equal(t, "Ihre Bestellung ORDER-<1> ist bestätigt", email.Subject)
+	assert.Contains(t, email.HTML, "Bestellung ansehen")
+
+	// Unsupported locales fall back to English
+	order.Locale = "xx"
+	email, err = RenderEmail(EmailOrderConfirmation, OrderConfirmationEmail(order, DefaultStoreURL))
+	require.NoError(t, err)
+	assert.Equal(t, "Your order ORDER-<1> is confirmed", email.Subject)
+
+	_, err = RenderEmail("unknown", EmailData{})
+	assert.Error(t, err)
+}
+
+func TestRenderOrderEmails(t *testing.T) {
+	cart := withVariants(CartState{Currency: "USD", Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}, CouponCode: "SAVE10"})
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+	order := Order{ID: "ORDER-1", CartID: "CART-1", Pricing: pricing, ShippingAddress: ShippingAddress{
+		Name: "Val Temporal", Line1: "1 Main Street", City: "Bellevue", Region: "WA", PostalCode: "98004", Country: "US",
+	}}
+
+	email, err := RenderEmail(EmailOrderConfirmation, OrderConfirmationEmail(order, DefaultStoreURL))
+	require.NoError(t, err)
+	assert.Contains(t, email.Text, "Order number: ORDER-1")
+	assert.Contains(t, email.Text, "We'll ship it to:\nVal Temporal\n1 Main Street\nBellevue, WA 98004\nUS\n")
+	assert.Contains(t, email.Text, "View your order: http://localhost:8080/#/orders/ORDER-1")
+	assert.Contains(t, email.HTML, "Val Temporal<br>1 Main Street<br>Bellevue, WA 98004<br>US")
+	assert.Contains(t, email.HTML, `href="http://localhost:8080/#/orders/ORDER-1"`)
+	assert.Contains(t, email.Text, "Discount: -$139.80")
+	assert.Contains(t, email.Text, "Shipping: $0.00")
+	assert.Contains(t, email.Text, "Tax: $91.22")
+	assert.Contains(t, email.HTML, "Order number: ORDER-1")
+
+	order.Shipment = Shipment{Carrier: "UPS", TrackingNumber: "1Z999"}
+	email, err = RenderEmail(EmailShippingNotification, ShippingNotificationEmail(order, DefaultStoreURL))
+	require.NoError(t, err)
+	assert.Equal(t, "Your order ORDER-1 is on its way", email.Subject)
+	assert.Contains(t, email.Text, "UPS tracking number: 1Z999")
+	assert.Contains(t, email.HTML, "UPS tracking number: 1Z999")
+
+	email, err = RenderEmail(EmailOrderCancellation, OrderCancellationEmail(order, DefaultStoreURL))
+	require.NoError(t, err)
+	assert.Equal(t, "Your order ORDER-1 has been cancelled", email.Subject)
+	assert.Contains(t, email.Text, "You have not been charged")
+
+	order.Refunded = pricing.Total
+	email, err = RenderEmail(EmailOrderCancellation, OrderCancellationEmail(order, DefaultStoreURL))
+	require.NoError(t, err)
+	assert.Contains(t, email.Text, "We have refunded $1,349.42")
+	assert.Contains(t, email.HTML, "We have refunded $1,349.42")
+
+	// Amounts are written the way the email's language writes them
+	order.Locale = "de"
+	email, err = RenderEmail(EmailOrderCancellation, OrderCancellationEmail(order, DefaultStoreURL))
+	require.NoError(t, err)
+	assert.Contains(t, email.Text, "Wir haben 1.349,42\u00a0$ auf Ihre Karte zurückerstattet")
+	assert.Contains(t, email.Text, "Gesamt: 1.349,42\u00a0$")
+}
+
+func TestMoneyFormatter(t *testing.T) {
+	for _, tt := range []struct {
+		locale string
+		money  Money
+		want   string
+	}{
+		{"en", Money{Amount: 139800, Currency: "USD"}, "$1,398.00"},
+		{"en", Money{Amount: -13980, Currency: "GBP"}, "-£139.80"},
+		{"en", Money{Amount: 5, Currency: "KWD"}, "KWD\u00a00.005"},
+		{"de", Money{Amount: 139800, Currency: "EUR"}, "1.398,00\u00a0€"},
+		{"de", Money{Amount: 99, Currency: "USD"}, "0,99\u00a0$"},
+		{"fr", Money{Amount: 123456789, Currency: "EUR"}, "1\u202f234\u202f567,89\u00a0€"},
+		{"fr", Money{Amount: 129800, Currency: "JPY"}, "129\u202f800\u00a0¥"},
+		{"xx", Money{Amount: 100000, Currency: "JPY"}, "¥100,000"},
+	} {
+		assert.Equal(t, tt.want, moneyFormatter(tt.locale)(tt.money), "%s %s", tt.locale, tt.money)
+	}
+}
+
+func TestThumbnailURL(t *testing.T) {
+	assert.Equal(t, "https://img.example.com/a.jpg?w=160", thumbnailURL("https://img.example.com/a.jpg"))
+	// The image's own query is kept, and its width replaced
+	assert.Equal(t, "https://img.example.com/a.jpg?fit=crop&w=160", thumbnailURL("https://img.example.com/a.jpg?fit=crop&w=1000"))
+	assert.Equal(t, "", thumbnailURL(""))
+	assert.Equal(t, "", thumbnailURL("http://[::1"))
+}
+
+func TestEmailMessagesAreTranslated(t *testing.T) {
+	for _, locale := range SupportedLocales {
+		for key := range emailMessages[DefaultLocale] {
+			_, ok := emailMessages[locale][key]
+			assert.True(t, ok, "%s is missing %s", locale, key)
+		}
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	assert.Equal(t, "de", MatchLocale("de-CH,de;q=0.9,en;q=0.8"))
+	assert.Equal(t, "fr", MatchLocale("es, FR-be"))
+	assert.Equal(t, "en", MatchLocale("es"))
+	assert.Equal(t, "en", MatchLocale(""))
+}
@@ -2,7 +2,9 @@
 
 const API = 'http://localhost:3001';
 
-exports.addToCart = function addToCart(workflowID, item) {
+// Carts hold variants, by SKU, so a variant from a product's Variants is
+// added rather than the product itself.
+exports.addToCart = function addToCart(workflowID, variant) {
   return fetch(`${API}/cart/${workflowID}/add`, {
     method: 'PUT',
     headers: {
@@ -10,25 +12,28 @@ exports.addToCart = function addToCart(workflowID, item) {
       'Content-Type': 'application/json'
     },
     body: JSON.stringify({
-      ProductId: item.Id,
+      SKU: variant.SKU,
       Quantity: 1,
+      Currency: variant.Price && variant.Price.Currency,
     })
   }).then(_checkForError).then(res => res.json());
 };
 
-exports.checkout = function checkout(workflowID, email) {
+// shippingAddress has the fields of app.ShippingAddress: Name, Line1, Line2,
+// City, Region, PostalCode and a two letter Country.
+exports.checkout = function checkout(workflowID, email, shippingAddress) {
   return fetch(`${API}/cart/${workflowID}/checkout`, {
     method: 'PUT',
     headers: {
       accept: 'application/json',
       'Content-Type': 'application/json'
     },
-    body: JSON.stringify({ email })
+    body: JSON.stringify({ email, shippingAddress })
   }).then(_checkForError).then(res => res.json());
 };
 
-exports.createCart = function createCart() {
-  return fetch(`${API}/cart`, {
+exports.createCart = function createCart(currency) {
+  return fetch(`${API}/cart?currency=${encodeURIComponent(currency || 'USD')}`, {
     method: "POST",
     headers: {
       accept: "application/json",
@@ -47,8 +52,18 @@ exports.getCart = function getCart(workflowID) {
   }).then(_checkForError).then(res => res.json());
 };
 
-exports.getProducts = function getProducts() {
-  return fetch(`${API}/products`, {
+exports.getOrder = function getOrder(orderID) {
+  return fetch(`${API}/orders/${encodeURIComponent(orderID)}`, {
+    method: 'GET',
+    headers: {
+      accept: 'application/json',
+      'Content-Type': 'application/json'
+    }
+  }).then(_checkForError).then(res => res.json());
+};
+
+exports.getProducts = function getProducts(currency) {
+  return fetch(`${API}/products?currency=${encodeURIComponent(currency || 'USD')}`, {
     method: 'GET',
     headers: {
       accept: 'application/json',
@@ -65,7 +80,7 @@ exports.removeFromCart = function removeFromCart(workflowID, item) {
       'Content-Type': 'application/json'
     },
     body: JSON.stringify({
-      ProductId: item.Id,
+      SKU: item.SKU,
       Quantity: 1
     })
   }).then(_checkForError).then(res => res.json());
@@ -73,7 +88,21 @@ exports.removeFromCart = function removeFromCart(workflowID, item) {
 
 function _checkForError(res) {
   if (res.status == null || res.status >= 400) {
-    throw new Error(`Request failed with status ${res.status}`);
+    const err = new Error(`Request failed with status ${res.status}`);
+    err.status = res.status;
+    if (typeof res.json !== 'function') {
+      throw err;
+    }
+    // The API explains rejected cart changes and failed checkouts in the body
+    return res.json().catch(() => ({})).then(body => {
+      if (body.Message) {
+        err.message = body.Message;
+      }
+      err.type = body.Type;
+      err.checkout = body.Checkout;
+      err.startNewCart = !!body.StartNewCart;
+      throw err;
+    });
   }
   return res;
 }
\ No newline at end of file
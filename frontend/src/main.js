@@ -14,6 +14,7 @@ const app = Vue.createApp({
 
 app.component('Cart', require('./views/Cart'));
 app.component('Checkout', require('./views/Checkout'));
+app.component('Order', require('./views/Order'));
 app.component('Store', require('./views/Store'));
 
 const router = VueRouter.createRouter({
@@ -38,6 +39,12 @@ const router = VueRouter.createRouter({
       path: '/checkout',
       name: 'Checkout',
       component: app.component('Checkout')
+    },
+    {
+      // Order emails link here
+      path: '/orders/:orderID',
+      name: 'Order',
+      component: app.component('Order')
     }
   ]
 });
@@ -21,12 +21,12 @@ module.exports = {
     },
     removeItem(item) {
       return api.removeFromCart(localStorage.getItem('workflow'), item).then(() => {
-        const existingItem = this.cart.find(i => i.ProductId === item.Id);
+        const existingItem = this.cart.find(i => i.SKU === item.SKU);
         if (!existingItem) {
           return;
         }
         if (existingItem.Quantity === 1) {
-          this.cart = this.cart.filter(i => i.ProductId !== item.Id);
+          this.cart = this.cart.filter(i => i.SKU !== item.SKU);
         } else {
           existingItem.Quantity -= 1;
         }
@@ -34,6 +34,10 @@ module.exports = {
     },
   },
   created() {
+    // Links in emails open a specific cart
+    if (this.$route.query.workflow) {
+      localStorage.setItem('workflow', this.$route.query.workflow);
+    }
     if (!localStorage.getItem('workflow')) {
       this.loading = false;
       return;
@@ -52,6 +56,13 @@ module.exports = {
         this.loading = false;
       })
       .catch((err) => {
+        if (err.startNewCart) {
+          // The cart expired. The store starts a new one on the next visit.
+          localStorage.setItem('workflow', '');
+          this.cart = [];
+          this.loading = false;
+          return;
+        }
         alert('Error fetching products: ' + err);
         this.loading = false
       });
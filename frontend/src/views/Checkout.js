@@ -9,7 +9,18 @@ module.exports = {
   data() {
     return {
       success: false,
+      error: null,
+      orderID: null,
       email: null,
+      shippingAddress: {
+        Name: '',
+        Line1: '',
+        Line2: '',
+        City: '',
+        Region: '',
+        PostalCode: '',
+        Country: '',
+      },
       items: [],
     };
   },
@@ -17,16 +28,19 @@ module.exports = {
   methods: {
     endCheckout() {
       if (this.email == null) return;
-      api.checkout(localStorage.getItem('workflow'), this.email)
+      this.error = null;
+      const shippingAddress = { ...this.shippingAddress, Country: this.shippingAddress.Country.trim().toUpperCase() };
+      return api.checkout(localStorage.getItem('workflow'), this.email, shippingAddress)
         .then((response) => {
           localStorage.setItem('workflow', '');
           this.items = [];
-          console.log(response);
+          this.orderID = response.orderID;
+          this.success = true;
         })
         .catch((err) => {
           console.log(err);
+          this.error = err.message;
         });
-      this.success = true;
     },
   },
   created() {
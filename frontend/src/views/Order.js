@@ -0,0 +1,49 @@
+'use strict';
+
+const BaseComponent = require('./BaseComponent');
+const api = require('../api');
+const template = require('./Order.html');
+
+const regionBeforePostalCode = ['US', 'CA', 'AU', 'GB'];
+
+module.exports = {
+  template,
+  data() {
+    return {
+      loading: true,
+      error: null,
+      order: null,
+    };
+  },
+  extends: BaseComponent,
+  computed: {
+    // The address as it goes on the label, see app.ShippingAddress.Lines
+    addressLines() {
+      const address = this.order && this.order.ShippingAddress;
+      if (address == null || !address.Line1) {
+        return [];
+      }
+      const lines = [address.Name, address.Line1, address.Line2];
+      if (regionBeforePostalCode.includes(address.Country)) {
+        lines.push(`${address.City}${address.Region ? ', ' + address.Region : ''} ${address.PostalCode}`);
+      } else {
+        lines.push(`${address.PostalCode} ${address.City}`, address.Region);
+      }
+      lines.push(address.Country);
+      return lines.filter(Boolean);
+    },
+  },
+  created() {
+    api.getOrder(this.$route.params.orderID)
+      .then((order) => {
+        this.order = order;
+      })
+      .catch((err) => {
+        console.log(err);
+        this.error = err.message;
+      })
+      .finally(() => {
+        this.loading = false;
+      });
+  }
+};
\ No newline at end of file
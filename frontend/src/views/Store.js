@@ -9,14 +9,21 @@ module.exports = {
   data() {
     return {
       items: null,
+      // selected is the SKU picked for each product, by product ID
+      selected: {},
       added: false,
       error: null,
     };
   },
   extends: BaseComponent,
   methods: {
+    variantLabel(variant) {
+      const options = (variant.Options || []).map(option => option.Value).join(' / ');
+      return `${options || variant.Name} - ${variant.Price.Amount} ${variant.Price.Currency}`;
+    },
     addToCart(item) {
-      api.addToCart(localStorage.getItem('workflow'), item)
+      const variant = item.Variants.find(v => v.SKU === this.selected[item.Id]) || item.Variants[0];
+      api.addToCart(localStorage.getItem('workflow'), variant)
         .then(() => {
           this.added = true;
           setTimeout(() => {
@@ -24,6 +31,10 @@ module.exports = {
           }, 1000);
         })
         .catch((err) => {
+          if (err.startNewCart) {
+            // The cart expired, so put the item in a fresh one
+            return this.createNewCart().then(() => this.addToCart(item));
+          }
           this.error = true;
           setTimeout(() => {
             this.error = false;
@@ -32,7 +43,7 @@ module.exports = {
         });
     },
     createNewCart() {
-      api.createCart()
+      return api.createCart(localStorage.getItem('currency'))
         .then((data) => {
           localStorage.setItem("workflow", data.workflowID);
         })
@@ -42,8 +53,11 @@ module.exports = {
     },
   },
   created() {
-    api.getProducts()
+    api.getProducts(localStorage.getItem('currency'))
       .then((data) => {
+        for (const product of data.products) {
+          this.selected[product.Id] = product.Variants[0].SKU;
+        }
         return (this.items = data.products);
       })
       .catch((err) => {
@@ -14,11 +14,12 @@ describe('Cart', function() {
     localStorage.setItem('workflow', 'test-workflow-id');
 
     sinon.stub(api, 'getCart').callsFake(() => Promise.resolve({
-      Items: [{ ProductId: 0, Quantity: 2 }]
+      Items: [{ SKU: 'IP12-64-BLK', Quantity: 2 }]
     }));
     sinon.stub(api, 'getProducts').callsFake(() => Promise.resolve({
       products: [
-        { Id: 0, Name: 'iPhone 12', Description: 'test', image: 'test-image', price: 10 }
+        { Id: 1, Name: 'iPhone 12', Description: 'test', image: 'test-image', price: 10,
+          Variants: [{ SKU: 'IP12-64-BLK', Name: 'iPhone 12 64GB Black', price: 10 }] }
       ]
     }));
   });
@@ -57,8 +58,8 @@ describe('Cart', function() {
     const cartInstance = appInstance.$options.$children[0];
     sinon.stub(api, 'removeFromCart').callsFake(() => Promise.resolve());
 
-    assert.deepStrictEqual([...cartInstance.cart], [{ ProductId: 0, Quantity: 2 }]);
-    await cartInstance.removeItem({ Id: 0 });
-    assert.deepStrictEqual([...cartInstance.cart], [{ ProductId: 0, Quantity: 1 }]);
+    assert.deepStrictEqual([...cartInstance.cart], [{ SKU: 'IP12-64-BLK', Quantity: 2 }]);
+    await cartInstance.removeItem({ SKU: 'IP12-64-BLK' });
+    assert.deepStrictEqual([...cartInstance.cart], [{ SKU: 'IP12-64-BLK', Quantity: 1 }]);
   });
 });
\ No newline at end of file
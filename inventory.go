@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// InventoryStore tracks how many units of each SKU are in stock and how
+// many of them are held by open carts. Carts are identified by workflow ID.
+type InventoryStore interface {
+	// Reserve sets the number of units of a SKU held by a cart. Because
+	// it sets rather than adds, repeating a call is harmless. Lowering the
+	// quantity returns units to stock, and zero drops the hold entirely.
+	Reserve(ctx context.Context, cartID string, sku string, quantity int) error
+	// Release returns every unit held by the cart to stock.
+	Release(ctx context.Context, cartID string) error
+	// Commit turns the cart's holds into sales. The units leave stock for
+	// good and the cart no longer holds anything.
+	Commit(ctx context.Context, cartID string) error
+	// Available is the number of units that are neither sold nor held.
+	Available(ctx context.Context, sku string) (int, error)
+	// Restock puts units that came back with a return on sale again.
+	// Repeating a call for the same return and SKU is harmless.
+	Restock(ctx context.Context, returnID string, sku string, quantity int) error
+}
+
+var (
+	ErrOutOfStock      = errors.New("out of stock")
+	ErrInvalidQuantity = errors.New("invalid quantity")
+)
+
+// CatalogStock is the stock of each variant of the products, for a new
+// MemoryInventory.
+func CatalogStock(products []Product) map[string]int {
+	stock := make(map[string]int)
+	for _, product := range products {
+		for _, variant := range product.Variants {
+			stock[variant.SKU] = variant.Stock
+		}
+	}
+	return stock
+}
+
+// OpenInventory returns the inventory of the catalog's variants. A catalog
+// database keeps it in the same database, and any other catalog is stocked
+// in memory with its variants' stock.
+func OpenInventory(ctx context.Context, catalog Catalog) (InventoryStore, error) {
+	if database, ok := catalog.(*SQLiteCatalog); ok {
+		return database.Inventory(), nil
+	}
+	products, err := catalog.Products(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewMemoryInventory(CatalogStock(products)), nil
+}
+
+// MemoryInventory is an InventoryStore kept in process memory. It is only
+// suitable for tests and for running a single worker.
+type MemoryInventory struct {
+	mu    sync.Mutex
+	stock map[string]int
+	holds map[string]map[string]int
+	// restocked remembers the returns already put back, by return ID and
+	// SKU.
+	restocked map[string]map[string]bool
+}
+
+func NewMemoryInventory(stock map[string]int) *MemoryInventory {
+	inventory := &MemoryInventory{
+		stock:     make(map[string]int, len(stock)),
+		holds:     make(map[string]map[string]int),
+		restocked: make(map[string]map[string]bool),
+	}
+	for sku, quantity := range stock {
+		inventory.stock[sku] = quantity
+	}
+	return inventory
+}
+
+func (m *MemoryInventory) Reserve(_ context.Context, cartID string, sku string, quantity int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if quantity < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidQuantity, quantity)
+	}
+	if _, ok := m.stock[sku]; !ok {
+		return fmt.Errorf("%w: SKU %s is not stocked", ErrOutOfStock, sku)
+	}
+
+	held := m.holds[cartID][sku]
+	if available := m.available(sku) + held; quantity > available {
+		return fmt.Errorf("%w: %d of SKU %s requested, %d available", ErrOutOfStock, quantity, sku, available)
+	}
+
+	if quantity == 0 {
+		delete(m.holds[cartID], sku)
+		return nil
+	}
+	if m.holds[cartID] == nil {
+		m.holds[cartID] = make(map[string]int)
+	}
+	m.holds[cartID][sku] = quantity
+	return nil
+}
+
+func (m *MemoryInventory) Release(_ context.Context, cartID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.holds, cartID)
+	return nil
+}
+
+func (m *MemoryInventory) Commit(_ context.Context, cartID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for sku, quantity := range m.holds[cartID] {
+		m.stock[sku] -= quantity
+	}
+	delete(m.holds, cartID)
+	return nil
+}
+
+func (m *MemoryInventory) Available(_ context.Context, sku string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.available(sku), nil
+}
+
+func (m *MemoryInventory) Restock(_ context.Context, returnID string, sku string, quantity int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if quantity <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidQuantity, quantity)
+	}
+	if m.restocked[returnID][sku] {
+		return nil
+	}
+	if m.restocked[returnID] == nil {
+		m.restocked[returnID] = make(map[string]bool)
+	}
+	m.restocked[returnID][sku] = true
+	m.stock[sku] += quantity
+	return nil
+}
+
+func (m *MemoryInventory) available(sku string) int {
+	available := m.stock[sku]
+	for _, holds := range m.holds {
+		available -= holds[sku]
+	}
+	return available
+}
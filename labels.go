@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// LabelProvider issues prepaid shipping labels for returns.
+type LabelProvider interface {
+	// CreateReturnLabel issues the label for a return. Asking again for the
+	// same return must give the same label, so activity retries are safe.
+	CreateReturnLabel(ctx context.Context, returnID string) (ReturnLabel, error)
+}
+
+// FakeLabelProvider is an in-memory LabelProvider for tests and local
+// development. Its labels cannot actually be shipped with.
+type FakeLabelProvider struct {
+	mu     sync.Mutex
+	labels map[string]ReturnLabel
+}
+
+func NewFakeLabelProvider() *FakeLabelProvider {
+	return &FakeLabelProvider{labels: make(map[string]ReturnLabel)}
+}
+
+func (p *FakeLabelProvider) CreateReturnLabel(_ context.Context, returnID string) (ReturnLabel, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if label, ok := p.labels[returnID]; ok {
+		return label, nil
+	}
+	label := ReturnLabel{
+		Carrier:        "UPS",
+		TrackingNumber: fmt.Sprintf("1ZRETURN%08d", len(p.labels)+1),
+		URL:            "https://labels.example.com/" + returnID + ".pdf",
+	}
+	p.labels[returnID] = label
+	return label, nil
+}
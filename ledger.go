@@ -0,0 +1,149 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+type LedgerEntryType string
+
+const (
+	LedgerCharge LedgerEntryType = "charge"
+	LedgerRefund LedgerEntryType = "refund"
+)
+
+// LedgerEntry is money that moved for an order: the captured payment, or a
+// refund of some of it.
+type LedgerEntry struct {
+	Type   LedgerEntryType
+	Amount Money
+	At     time.Time
+	// Reference is the idempotency key the payment provider was sent, or the
+	// ID of the return the refund was for.
+	Reference string
+	Reason    string
+	// Items are what a refund was for, if it was for particular items.
+	Items []CartItem
+}
+
+// RefundRequest asks for part of an order's payment back. It refunds either
+// the given items, at what was paid for them, or a given amount. A request
+// with neither refunds everything that is left.
+type RefundRequest struct {
+	Items  []CartItem
+	Amount Money
+	Reason string
+}
+
+// record adds an entry to the order's ledger and keeps Refunded and
+// PaymentStatus in step with it.
+func (order *Order) record(entry LedgerEntry) {
+	order.Ledger = append(order.Ledger, entry)
+	if entry.Type != LedgerRefund {
+		return
+	}
+	order.Refunded.Currency = entry.Amount.Currency
+	order.Refunded.Amount += entry.Amount.Amount
+	for _, item := range entry.Items {
+		order.RefundedItems = addItem(order.RefundedItems, item)
+	}
+	if charged := order.charged(); charged.Amount > 0 && order.Refunded.Amount >= charged.Amount {
+		order.PaymentStatus = PaymentRefunded
+	}
+}
+
+// addItem adds units to a list of items with one entry per SKU.
+func addItem(items []CartItem, item CartItem) []CartItem {
+	for i := range items {
+		if items[i].SKU == item.SKU {
+			items[i].Quantity += item.Quantity
+			return items
+		}
+	}
+	return append(items, item)
+}
+
+// charged is the total of the order's charges.
+func (order *Order) charged() Money {
+	charged := Money{Currency: order.Pricing.Total.Currency}
+	for _, entry := range order.Ledger {
+		if entry.Type == LedgerCharge {
+			charged.Amount += entry.Amount.Amount
+		}
+	}
+	return charged
+}
+
+// refundable is how much of the order's charges can still be refunded. The
+// most each return in progress may refund is held back for it.
+func (order *Order) refundable() Money {
+	refundable := order.charged()
+	refundable.Amount -= order.Refunded.Amount
+	for _, r := range order.Returns {
+		if !r.open() || r.Status == ReturnRefunded {
+			continue
+		}
+		if held, err := ReturnRefund(order.Pricing, r.Items); err == nil {
+			refundable.Amount -= held.Amount
+		}
+	}
+	if refundable.Amount < 0 {
+		refundable.Amount = 0
+	}
+	return refundable
+}
+
+// refundAmount works out what a refund request comes to, and refuses it if
+// that is more than is left to refund. Like the cart's validators, it must not
+// change the order.
+func (order *Order) refundAmount(request RefundRequest) (Money, error) {
+	if order.PaymentStatus != PaymentCaptured {
+		return Money{}, temporal.NewNonRetryableApplicationError(fmt.Sprintf("payment is %s", order.PaymentStatus), ErrorTypeOrderStatus, nil)
+	}
+	if len(request.Items) > 0 && !request.Amount.IsZero() {
+		return Money{}, invalidRequest("refund either items or an amount, not both")
+	}
+
+	refundable := order.refundable()
+	amount := refundable
+	switch {
+	case len(request.Items) > 0:
+		var requested []CartItem
+		for _, item := range request.Items {
+			if item.Quantity <= 0 {
+				return Money{}, invalidRequest("quantity must be positive, got %d", item.Quantity)
+			}
+			requested = addItem(requested, item)
+		}
+		// Units already refunded, or in a return, cannot be refunded again.
+		for _, item := range requested {
+			if bought := itemQuantity(order.Items, item.SKU); item.Quantity > bought {
+				return Money{}, invalidRequest("%d units of SKU %s were bought, not %d", bought, item.SKU, item.Quantity)
+			}
+			if left := order.unclaimed(item.SKU); item.Quantity > left {
+				return Money{}, temporal.NewNonRetryableApplicationError(
+					fmt.Sprintf("%d units of SKU %s can be refunded, not %d", left, item.SKU, item.Quantity), ErrorTypeRefundExceeded, nil)
+			}
+		}
+		var err error
+		if amount, err = ReturnRefund(order.Pricing, request.Items); err != nil {
+			return Money{}, invalidRequest("%v", err)
+		}
+	case !request.Amount.IsZero():
+		if request.Amount.Currency != refundable.Currency {
+			return Money{}, invalidRequest("the order was paid in %s, not %s", refundable.Currency, request.Amount.Currency)
+		}
+		if request.Amount.Amount < 0 {
+			return Money{}, invalidRequest("refund amount must be positive, got %s", request.Amount)
+		}
+		amount = request.Amount
+	}
+
+	if amount.IsZero() || amount.Amount > refundable.Amount {
+		return Money{}, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("cannot refund %s, %s is left to refund", amount, refundable), ErrorTypeRefundExceeded, nil)
+	}
+	return amount, nil
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundAmount(t *testing.T) {
+	cart := withVariants(CartState{Currency: "USD", Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}})
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+	order := Order{
+		Items:         cart.Items,
+		Pricing:       pricing,
+		PaymentStatus: PaymentCaptured,
+	}
+	order.record(LedgerEntry{Type: LedgerCharge, Amount: pricing.Total})
+
+	amount, err := order.refundAmount(RefundRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, pricing.Total, amount)
+
+	// 699.00 plus 7.25% tax
+	amount, err = order.refundAmount(RefundRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}})
+	require.NoError(t, err)
+	assert.Equal(t, Money{Amount: 74968, Currency: "USD"}, amount)
+
+	order.record(LedgerEntry{Type: LedgerRefund, Amount: Money{Amount: 50000, Currency: "USD"}})
+	// A return in progress holds back what it may refund.
+	order.Returns = []Return{{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}, Status: ReturnLabelIssued}}
+	assert.Equal(t, Money{Amount: pricing.Total.Amount - 50000 - 74968, Currency: "USD"}, order.refundable())
+
+	for _, request := range []RefundRequest{
+		{Amount: Money{Amount: 1, Currency: "EUR"}},
+		{Amount: Money{Amount: -1, Currency: "USD"}},
+		{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 3}}},
+		{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 0}}},
+		{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}, Amount: Money{Amount: 1, Currency: "USD"}},
+	} {
+		_, err := order.refundAmount(request)
+		assert.Equal(t, ErrorTypeInvalidRequest, applicationErrorType(err), "%+v", request)
+	}
+
+	_, err = order.refundAmount(RefundRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}})
+	assert.Equal(t, ErrorTypeRefundExceeded, applicationErrorType(err))
+
+	order.Returns[0].Status = ReturnRejected
+	order.record(LedgerEntry{Type: LedgerRefund, Amount: order.refundable()})
+	assert.Equal(t, PaymentRefunded, order.PaymentStatus)
+	assert.Equal(t, pricing.Total, order.Refunded)
+	_, err = order.refundAmount(RefundRequest{})
+	assert.Equal(t, ErrorTypeOrderStatus, applicationErrorType(err))
+}
+
+func TestRefundItemsOnce(t *testing.T) {
+	cart := withVariants(CartState{Currency: "USD", Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 3}}})
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+	deliveredAt := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	order := Order{
+		Items:         cart.Items,
+		Pricing:       pricing,
+		PaymentStatus: PaymentCaptured,
+		Status:        OrderDelivered,
+		Shipment:      Shipment{DeliveredAt: deliveredAt},
+	}
+	order.record(LedgerEntry{Type: LedgerCharge, Amount: pricing.Total})
+	one := []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}
+
+	amount, err := order.refundAmount(RefundRequest{Items: one})
+	require.NoError(t, err)
+	order.record(LedgerEntry{Type: LedgerRefund, Amount: amount, Items: one})
+	assert.Equal(t, one, order.RefundedItems)
+
+	// A return took another unit back and was refunded for it
+	order.Returns = []Return{{Items: one, Accepted: one, Status: ReturnRefunded, Refund: amount}}
+	order.record(LedgerEntry{Type: LedgerRefund, Amount: amount, Items: one})
+	assert.Equal(t, []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}, order.RefundedItems)
+	assert.Equal(t, 1, order.unclaimed("IP12-64-BLK"))
+
+	// Only the last unit is left, however the request is split up
+	_, err = order.refundAmount(RefundRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}, {SKU: "IP12-64-BLK", Quantity: 1}}})
+	assert.Equal(t, ErrorTypeRefundExceeded, applicationErrorType(err))
+	_, err = order.refundAmount(RefundRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 4}}})
+	assert.Equal(t, ErrorTypeInvalidRequest, applicationErrorType(err))
+	_, err = order.refundAmount(RefundRequest{Items: one})
+	assert.NoError(t, err)
+
+	// Refunded units cannot be returned either
+	err = order.validateReturn(ReturnRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}}, deliveredAt.Add(time.Hour))
+	assert.Equal(t, ErrorTypeInvalidRequest, applicationErrorType(err))
+	assert.NoError(t, order.validateReturn(ReturnRequest{Items: one}, deliveredAt.Add(time.Hour)))
+}
+
+func TestFakePaymentProviderRefunds(t *testing.T) {
+	ctx := context.Background()
+	payments := NewFakePaymentProvider()
+	amount := Money{Amount: 1000, Currency: "USD"}
+	payment, err := payments.Authorize(ctx, PaymentRequest{Amount: amount, IdempotencyKey: "authorize"})
+	require.NoError(t, err)
+	_, err = payments.Capture(ctx, payment.ID, "capture")
+	require.NoError(t, err)
+
+	payment, err = payments.Refund(ctx, payment.ID, Money{Amount: 400, Currency: "USD"}, "refund-1")
+	require.NoError(t, err)
+	assert.Equal(t, PaymentCaptured, payment.Status)
+
+	_, err = payments.Refund(ctx, payment.ID, Money{Amount: 601, Currency: "USD"}, "refund-2")
+	assert.ErrorIs(t, err, ErrRefundExceedsCapture)
+
+	payment, err = payments.Refund(ctx, payment.ID, Money{Amount: 600, Currency: "USD"}, "refund-3")
+	require.NoError(t, err)
+	assert.Equal(t, PaymentRefunded, payment.Status)
+	assert.Equal(t, amount, payment.Refunded)
+}
@@ -0,0 +1,218 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+const DefaultLocale = "en"
+
+var SupportedLocales = []string{"en", "de", "fr"}
+
+func IsSupportedLocale(locale string) bool {
+	for _, l := range SupportedLocales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchLocale picks the first supported language from an Accept-Language
+// header or a plain list like "de-CH, en". Regions are ignored, and
+// DefaultLocale is used if nothing matches.
+func MatchLocale(accept string) string {
+	for _, tag := range strings.Split(accept, ",") {
+		tag, _, _ = strings.Cut(tag, ";")
+		tag, _, _ = strings.Cut(strings.TrimSpace(tag), "-")
+		tag = strings.ToLower(tag)
+		if IsSupportedLocale(tag) {
+			return tag
+		}
+	}
+	return DefaultLocale
+}
+
+// emailMessages are the translated strings email templates look up with t.
+// Every locale must have every key of DefaultLocale.
+var emailMessages = map[string]map[string]string{
+	"en": {
+		"abandoned_cart.subject.reminder": "You've abandoned your shopping cart!",
+		"abandoned_cart.subject.discount": "A little something to help you check out",
+		"abandoned_cart.subject.final":    "Last chance to check out your cart",
+		"abandoned_cart.intro.reminder":   "You left these items in your cart:",
+		"abandoned_cart.intro.discount":   "Your cart is still waiting for you. Use code %s at checkout to save on it.",
+		"abandoned_cart.intro.final":      "This is the last reminder about your cart. It won't be kept for much longer.",
+		"abandoned_cart.action":           "Finish checking out",
+
+		"order_confirmation.subject":  "Your order %s is confirmed",
+		"order_confirmation.intro":    "Thank you for your order! We will charge %s once it ships.",
+		"order_confirmation.ship_to":  "We'll ship it to:",
+		"order_confirmation.shipping": "We'll send you another email with the tracking details once your order ships.",
+
+		"shipping_notification.subject":  "Your order %s is on its way",
+		"shipping_notification.intro":    "Good news! Your order %s has shipped and we have charged %s.",
+		"shipping_notification.tracking": "%s tracking number: %s",
+
+		"order_cancellation.subject":  "Your order %s has been cancelled",
+		"order_cancellation.intro":    "Your order %s has been cancelled.",
+		"order_cancellation.voided":   "You have not been charged, and the hold on your card has been released.",
+		"order_cancellation.refunded": "We have refunded %s to your card. It can take a few days to show up.",
+
+		"order.number": "Order number: %s",
+		"order.action": "View your order",
+
+		"lines.quantity": "Quantity",
+		"lines.discount": "Discount",
+		"lines.shipping": "Shipping",
+		"lines.tax":      "Tax",
+		"lines.total":    "Total",
+	},
+	"de": {
+		"abandoned_cart.subject.reminder": "Sie haben Ihren Warenkorb vergessen!",
+		"abandoned_cart.subject.discount": "Eine kleine Hilfe für Ihre Bestellung",
+		"abandoned_cart.subject.final":    "Letzte Gelegenheit für Ihren Warenkorb",
+		"abandoned_cart.intro.reminder":   "Diese Artikel liegen noch in Ihrem Warenkorb:",
+		"abandoned_cart.intro.discount":   "Ihr Warenkorb wartet noch auf Sie. Mit dem Code %s sparen Sie bei der Bestellung.",
+		"abandoned_cart.intro.final":      "Dies ist die letzte Erinnerung an Ihren Warenkorb. Er wird nicht mehr lange aufbewahrt.",
+		"abandoned_cart.action":           "Jetzt bestellen",
+
+		"order_confirmation.subject":  "Ihre Bestellung %s ist bestätigt",
+		"order_confirmation.intro":    "Vielen Dank für Ihre Bestellung! Wir belasten %s, sobald sie versandt wird.",
+		"order_confirmation.ship_to":  "Lieferadresse:",
+		"order_confirmation.shipping": "Sobald Ihre Bestellung versandt wird, schicken wir Ihnen eine weitere E-Mail mit der Sendungsverfolgung.",
+
+		"shipping_notification.subject":  "Ihre Bestellung %s ist unterwegs",
+		"shipping_notification.intro":    "Gute Nachrichten! Ihre Bestellung %s wurde versandt und wir haben %s belastet.",
+		"shipping_notification.tracking": "Sendungsnummer (%s): %s",
+
+		"order_cancellation.subject":  "Ihre Bestellung %s wurde storniert",
+		"order_cancellation.intro":    "Ihre Bestellung %s wurde storniert.",
+		"order_cancellation.voided":   "Ihnen wurde nichts berechnet, und die Reservierung auf Ihrer Karte wurde aufgehoben.",
+		"order_cancellation.refunded": "Wir haben %s auf Ihre Karte zurückerstattet. Es kann einige Tage dauern, bis der Betrag erscheint.",
+
+		"order.number": "Bestellnummer: %s",
+		"order.action": "Bestellung ansehen",
+
+		"lines.quantity": "Menge",
+		"lines.discount": "Rabatt",
+		"lines.shipping": "Versand",
+		"lines.tax":      "MwSt.",
+		"lines.total":    "Gesamt",
+	},
+	"fr": {
+		"abandoned_cart.subject.reminder": "Vous avez oublié votre panier !",
+		"abandoned_cart.subject.discount": "Un petit coup de pouce pour votre commande",
+		"abandoned_cart.subject.final":    "Dernière chance pour votre panier",
+		"abandoned_cart.intro.reminder":   "Ces articles sont toujours dans votre panier :",
+		"abandoned_cart.intro.discount":   "Votre panier vous attend. Utilisez le code %s pour économiser sur votre commande.",
+		"abandoned_cart.intro.final":      "Ceci est le dernier rappel concernant votre panier. Il ne sera plus conservé très longtemps.",
+		"abandoned_cart.action":           "Finaliser ma commande",
+
+		"order_confirmation.subject":  "Votre commande %s est confirmée",
+		"order_confirmation.intro":    "Merci pour votre commande ! Nous prélèverons %s lors de l'expédition.",
+		"order_confirmation.ship_to":  "Adresse de livraison :",
+		"order_confirmation.shipping": "Nous vous enverrons un autre e-mail avec le numéro de suivi dès l'expédition de votre commande.",
+
+		"shipping_notification.subject":  "Votre commande %s est en route",
+		"shipping_notification.intro":    "Bonne nouvelle ! Votre commande %s a été expédiée et nous avons prélevé %s.",
+		"shipping_notification.tracking": "Numéro de suivi %s : %s",
+
+		"order_cancellation.subject":  "Votre commande %s a été annulée",
+		"order_cancellation.intro":    "Votre commande %s a été annulée.",
+		"order_cancellation.voided":   "Vous n'avez pas été débité et la réservation sur votre carte a été levée.",
+		"order_cancellation.refunded": "Nous avons remboursé %s sur votre carte. Le remboursement peut prendre quelques jours.",
+
+		"order.number": "Numéro de commande : %s",
+		"order.action": "Voir ma commande",
+
+		"lines.quantity": "Quantité",
+		"lines.discount": "Remise",
+		"lines.shipping": "Livraison",
+		"lines.tax":      "TVA",
+		"lines.total":    "Total",
+	},
+}
+
+// moneyFormat is how a locale writes an amount of money.
+type moneyFormat struct {
+	decimal string
+	group   string
+	// symbolAfter puts the currency after the amount rather than before it.
+	symbolAfter bool
+}
+
+var moneyFormats = map[string]moneyFormat{
+	"en": {decimal: ".", group: ","},
+	"de": {decimal: ",", group: ".", symbolAfter: true},
+	"fr": {decimal: ",", group: "\u202f", symbolAfter: true},
+}
+
+// currencySymbols are written in place of the currency code. Other
+// currencies are written with their code.
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+}
+
+// moneyFormatter returns the money function of email templates for a
+// locale, which writes 1398.00 USD as "$1,398.00" in English, "1.398,00 $" in
+// German and "1 398,00 $" in French. Unknown locales use DefaultLocale.
+func moneyFormatter(locale string) func(m Money) string {
+	format, ok := moneyFormats[locale]
+	if !ok {
+		format = moneyFormats[DefaultLocale]
+	}
+	return func(m Money) string {
+		amount := m.Decimal()
+		sign := ""
+		if strings.HasPrefix(amount, "-") {
+			sign, amount = "-", amount[1:]
+		}
+
+		whole, frac, hasFrac := strings.Cut(amount, ".")
+		var b strings.Builder
+		for i, digit := range whole {
+			if i > 0 && (len(whole)-i)%3 == 0 {
+				b.WriteString(format.group)
+			}
+			b.WriteRune(digit)
+		}
+		if hasFrac {
+			b.WriteString(format.decimal)
+			b.WriteString(frac)
+		}
+
+		symbol, ok := currencySymbols[m.Currency]
+		if !ok {
+			symbol = m.Currency
+		}
+		switch {
+		case format.symbolAfter:
+			return sign + b.String() + "\u00a0" + symbol
+		case !ok:
+			return sign + symbol + "\u00a0" + b.String()
+		}
+		return sign + symbol + b.String()
+	}
+}
+
+// translator returns the t function of email templates for a locale. Keys
+// missing from the locale fall back to DefaultLocale.
+func translator(locale string) func(key string, args ...interface{}) string {
+	return func(key string, args ...interface{}) string {
+		message, ok := emailMessages[locale][key]
+		if !ok {
+			message, ok = emailMessages[DefaultLocale][key]
+		}
+		if !ok {
+			return key
+		}
+		if len(args) == 0 {
+			return message
+		}
+		return fmt.Sprintf(message, args...)
+	}
+}
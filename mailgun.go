@@ -0,0 +1,29 @@
+package app
+
+import (
+	"context"
+
+	"github.com/mailgun/mailgun-go"
+)
+
+// MailgunSender is an EmailSender backed by the Mailgun API. Messages come
+// from noreply@ the sending domain.
+type MailgunSender struct {
+	Domain string
+	Key    string
+}
+
+func (s *MailgunSender) Send(_ context.Context, to string, email Email) error {
+	mg := mailgun.NewMailgun(s.Domain, s.Key)
+	m := mg.NewMessage(
+		"noreply@"+s.Domain,
+		email.Subject,
+		email.Text,
+		to,
+	)
+	if email.HTML != "" {
+		m.SetHtml(email.HTML)
+	}
+	_, _, err := mg.Send(m)
+	return err
+}
@@ -0,0 +1,182 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount in the minor unit of an ISO 4217 currency, e.g.
+// Money{Amount: 1999, Currency: "USD"} is $19.99. Never use floats for money.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// currencyExponents lists currencies whose minor unit is not 1/100 of the
+// major unit.
+var currencyExponents = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+func exponent(currency string) int {
+	if e, ok := currencyExponents[currency]; ok {
+		return e
+	}
+	return 2
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// ParseMoney parses a decimal amount in major units, such as "19.99", without
+// going through floating point.
+func ParseMoney(amount string, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	e := exponent(currency)
+
+	s := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+		if frac == "" {
+			return Money{}, fmt.Errorf("invalid %s amount %q", currency, amount)
+		}
+	}
+	if whole == "" || len(frac) > e {
+		return Money{}, fmt.Errorf("invalid %s amount %q", currency, amount)
+	}
+	frac += strings.Repeat("0", e-len(frac))
+
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || strings.ContainsAny(whole+frac, "+-") {
+		return Money{}, fmt.Errorf("invalid %s amount %q", currency, amount)
+	}
+	if negative {
+		units = -units
+	}
+
+	return Money{Amount: units, Currency: currency}, nil
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+// Cmp returns -1, 0 or 1 depending on whether m is less than, equal to or
+// greater than o.
+func (m Money) Cmp(o Money) (int, error) {
+	if m.Currency != o.Currency {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// BasisPoints returns bp/10000 of m, rounded half away from zero to the
+// nearest minor unit. 1250 basis points is 12.5%.
+func (m Money) BasisPoints(bp int64) Money {
+	product := m.Amount * bp
+	amount := product / 10000
+	remainder := product % 10000
+	if remainder >= 5000 {
+		amount++
+	} else if remainder <= -5000 {
+		amount--
+	}
+	return Money{Amount: amount, Currency: m.Currency}
+}
+
+// Share returns n/d of m, rounded down to the minor unit so that the shares
+// of a whole never add up to more than it.
+func (m Money) Share(n int64, d int64) Money {
+	return Money{Amount: m.Amount * n / d, Currency: m.Currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Decimal formats the amount in major units, e.g. "19.99".
+func (m Money) Decimal() string {
+	e := exponent(m.Currency)
+	units := m.Amount
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	if e == 0 {
+		return sign + strconv.FormatInt(units, 10)
+	}
+	p := pow10(e)
+	return fmt.Sprintf("%s%d.%0*d", sign, units/p, e, units%p)
+}
+
+// String formats the amount with its currency, e.g. "19.99 USD".
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+type moneyJSON struct {
+	Amount   string
+	Currency string
+}
+
+// MarshalJSON encodes the amount as a decimal string so that clients never
+// have to round-trip it through a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Currency: m.Currency})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var v moneyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Amount == "" && v.Currency == "" {
+		*m = Money{}
+		return nil
+	}
+
+	parsed, err := ParseMoney(v.Amount, v.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		amount   string
+		currency string
+		want     Money
+	}{
+		{"19.99", "USD", Money{Amount: 1999, Currency: "USD"}},
+		{"0.1", "usd", Money{Amount: 10, Currency: "USD"}},
+		{"999", "USD", Money{Amount: 99900, Currency: "USD"}},
+		{"-5.05", "EUR", Money{Amount: -505, Currency: "EUR"}},
+		{"1500", "JPY", Money{Amount: 1500, Currency: "JPY"}},
+		{"1.234", "KWD", Money{Amount: 1234, Currency: "KWD"}},
+	}
+	for _, c := range cases {
+		got, err := ParseMoney(c.amount, c.currency)
+		require.NoError(t, err, c.amount)
+		assert.Equal(t, c.want, got)
+	}
+
+	for _, bad := range []string{"", "abc", "1.999", "1.", ".5", "+1", "1.-5"} {
+		_, err := ParseMoney(bad, "USD")
+		assert.Error(t, err, bad)
+	}
+	_, err := ParseMoney("1.5", "JPY")
+	assert.Error(t, err)
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	price := Money{Amount: 1999, Currency: "USD"}
+
+	// 19.99 * 3 is exactly 59.97, which float32 cannot represent
+	total, err := price.Mul(3).Add(Money{Amount: 1, Currency: "USD"})
+	require.NoError(t, err)
+	assert.Equal(t, Money{Amount: 5998, Currency: "USD"}, total)
+
+	diff, err := total.Sub(price)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3999), diff.Amount)
+
+	cmp, err := price.Cmp(total)
+	require.NoError(t, err)
+	assert.Equal(t, -1, cmp)
+
+	assert.Equal(t, int64(150), price.BasisPoints(750).Amount)
+	assert.Equal(t, int64(-150), price.Mul(-1).BasisPoints(750).Amount)
+	assert.Equal(t, int64(1), Money{Amount: 5, Currency: "USD"}.BasisPoints(1000).Amount)
+	assert.Equal(t, int64(666), price.Share(1, 3).Amount)
+	assert.Equal(t, price, price.Share(3, 3))
+
+	_, err = price.Add(Money{Amount: 1, Currency: "EUR"})
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+}
+
+func TestMoneyFormatting(t *testing.T) {
+	assert.Equal(t, "19.99 USD", Money{Amount: 1999, Currency: "USD"}.String())
+	assert.Equal(t, "0.05", Money{Amount: 5, Currency: "USD"}.Decimal())
+	assert.Equal(t, "-1.50", Money{Amount: -150, Currency: "EUR"}.Decimal())
+	assert.Equal(t, "1500", Money{Amount: 1500, Currency: "JPY"}.Decimal())
+}
+
+func TestMoneyJSON(t *testing.T) {
+	price := Money{Amount: 1999, Currency: "USD"}
+
+	data, err := json.Marshal(price)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Amount":"19.99","Currency":"USD"}`, string(data))
+
+	var decoded Money
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, price, decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"Amount":"19.999","Currency":"USD"}`), &decoded))
+}
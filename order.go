@@ -0,0 +1,101 @@
+package app
+
+import (
+	"strings"
+	"time"
+)
+
+type OrderStatus string
+
+const (
+	OrderPlaced    OrderStatus = "placed"
+	OrderShipped   OrderStatus = "shipped"
+	OrderDelivered OrderStatus = "delivered"
+	OrderCancelled OrderStatus = "cancelled"
+)
+
+// Order is a checked out cart: what was bought, for how much, and how it was
+// paid for. Its OrderWorkflow keeps it up to date after checkout.
+type Order struct {
+	ID            string
+	CartID        string
+	Email         string
+	Locale        string
+	Items         []CartItem
+	Pricing       Pricing
+	PaymentID     string
+	PaymentStatus PaymentStatus
+	Status        OrderStatus
+	PlacedAt      time.Time
+	// ShippingAddress is where the order is sent, as given at checkout.
+	ShippingAddress ShippingAddress
+	Shipment        Shipment
+
+	// Set once the order is cancelled.
+	CancelledAt  time.Time
+	CancelReason string
+
+	// Ledger lists the order's charges and refunds, oldest first. Refunded
+	// is the total of the refunds, and RefundedItems the units they were
+	// for, per SKU, whether refunded on their own or by a return.
+	Ledger        []LedgerEntry
+	Refunded      Money
+	RefundedItems []CartItem
+
+	// Returns are the returns requested for the order, oldest first. Each is
+	// brought up to date once its ReturnWorkflow completes.
+	Returns []Return
+
+	// Rejections are the most recent signals the order refused.
+	Rejections []Rejection
+}
+
+// ShippingAddress is where an order is sent. Line2 and Region are optional,
+// and Country is an ISO 3166 code such as "DE".
+type ShippingAddress struct {
+	Name       string
+	Line1      string
+	Line2      string
+	City       string
+	Region     string
+	PostalCode string
+	Country    string
+}
+
+// regionBeforePostalCode lists the countries that write the postal code
+// after the city and region, as in "Springfield, IL 62701". Elsewhere it goes
+// before the city.
+var regionBeforePostalCode = map[string]bool{"US": true, "CA": true, "AU": true, "GB": true}
+
+// Lines writes the address out the way its country puts it on a label.
+func (a ShippingAddress) Lines() []string {
+	lines := []string{a.Name, a.Line1}
+	if a.Line2 != "" {
+		lines = append(lines, a.Line2)
+	}
+	if regionBeforePostalCode[a.Country] {
+		city := a.City
+		if a.Region != "" {
+			city += ", " + a.Region
+		}
+		lines = append(lines, city+" "+a.PostalCode)
+	} else {
+		lines = append(lines, a.PostalCode+" "+a.City)
+		if a.Region != "" {
+			lines = append(lines, a.Region)
+		}
+	}
+	return append(lines, a.Country)
+}
+
+func (a ShippingAddress) String() string {
+	return strings.Join(a.Lines(), ", ")
+}
+
+// Shipment is how an order was sent. It is set once fulfillment is confirmed.
+type Shipment struct {
+	Carrier        string
+	TrackingNumber string
+	ShippedAt      time.Time
+	DeliveredAt    time.Time
+}
@@ -0,0 +1,435 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// ErrOrderShipped is the reason an order refuses to be cancelled once it has
+// shipped.
+var ErrOrderShipped = temporal.NewNonRetryableApplicationError("order has shipped, return it instead", ErrorTypeOrderStatus, nil)
+
+var (
+	// Card authorizations expire after 7 days, so give up on fulfillment by then.
+	authorizationHoldTimeout = 7 * 24 * time.Hour
+
+	// An order that has shipped but is never confirmed delivered is left as
+	// shipped after this long.
+	deliveryTimeout = 30 * 24 * time.Hour
+)
+
+// OrderWorkflow owns an order from the moment its cart is checked out. It
+// holds the authorized payment until fulfillment is decided, captures or
+// voids it, and then follows the shipment until it is delivered. The
+// customer may cancel the order until it ships, and return items for the
+// returnWindow after delivery. Its workflow ID is the order ID.
+func OrderWorkflow(ctx workflow.Context, order Order) error {
+	logger := workflow.GetLogger(ctx)
+
+	err := workflow.SetQueryHandler(ctx, "getOrder", func(input []byte) (Order, error) {
+		return order, nil
+	})
+	if err != nil {
+		logger.Info("SetQueryHandler failed.", "Error", err)
+		return err
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	// pendingReturns counts the ReturnWorkflows still running.
+	pendingReturns := 0
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateNames.REQUEST_RETURN,
+		func(ctx workflow.Context, request ReturnRequest) (Return, error) {
+			// The order may have changed since the update was validated.
+			if err := order.validateReturn(request, workflow.Now(ctx)); err != nil {
+				return Return{}, err
+			}
+
+			ret := Return{
+				ID:           returnID(&order, len(order.Returns)+1),
+				OrderID:      order.ID,
+				PaymentID:    order.PaymentID,
+				OrderPricing: order.Pricing,
+				Items:        request.Items,
+				Reason:       request.Reason,
+				Status:       ReturnRequested,
+				RequestedAt:  workflow.Now(ctx),
+			}
+			n := len(order.Returns)
+			order.Returns = append(order.Returns, ret)
+
+			ctx = workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{WorkflowID: ret.ID})
+			child := workflow.ExecuteChildWorkflow(ctx, ReturnWorkflow, ret)
+			if err := child.GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+				logger.Error("Unable to start return", "ReturnID", ret.ID, "Error", err)
+				order.Returns[n].Status = ReturnRejected
+				order.Returns[n].Note = "the return could not be started"
+				return Return{}, err
+			}
+
+			pendingReturns++
+			workflow.Go(ctx, func(ctx workflow.Context) {
+				defer func() { pendingReturns-- }()
+				var result Return
+				if err := child.Get(ctx, &result); err != nil {
+					logger.Error("Return failed", "ReturnID", ret.ID, "Error", err)
+					return
+				}
+				order.Returns[n] = result
+				if !result.Refund.IsZero() {
+					order.record(LedgerEntry{
+						Type:      LedgerRefund,
+						Amount:    result.Refund,
+						At:        result.RefundedAt,
+						Reference: result.ID,
+						Reason:    result.Reason,
+						Items:     result.Accepted,
+					})
+				}
+			})
+			return ret, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request ReturnRequest) error {
+				return order.validateReturn(request, workflow.Now(ctx))
+			},
+		})
+	if err != nil {
+		return err
+	}
+
+	// refunds lets one refund through at a time, so that each is checked
+	// against what the ones before it left.
+	refunds := workflow.NewMutex(ctx)
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateNames.REFUND_ORDER,
+		func(ctx workflow.Context, request RefundRequest) (LedgerEntry, error) {
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			if err := refunds.Lock(ctx); err != nil {
+				return LedgerEntry{}, err
+			}
+			defer refunds.Unlock()
+
+			amount, err := order.refundAmount(request)
+			if err != nil {
+				return LedgerEntry{}, err
+			}
+			refund := LedgerEntry{
+				Type:   LedgerRefund,
+				Amount: amount,
+				// Resending the update with the same ID cannot refund twice.
+				Reference: orderPaymentKey(&order, "refund/"+workflow.GetCurrentUpdateInfo(ctx).ID),
+				Reason:    request.Reason,
+				Items:     request.Items,
+			}
+			if err := refundPayment(ctx, &order, refund); err != nil {
+				return LedgerEntry{}, updateError(err)
+			}
+			return order.Ledger[len(order.Ledger)-1], nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, request RefundRequest) error {
+				_, err := order.refundAmount(request)
+				return err
+			},
+		})
+	if err != nil {
+		return err
+	}
+
+	if order.Status == OrderPlaced {
+		if err := settlePayment(ctx, &order, refunds); err != nil {
+			return err
+		}
+	}
+
+	if order.Status == OrderShipped {
+		awaitDelivery(ctx, &order)
+	}
+
+	if order.Status == OrderDelivered {
+		awaitReturnWindow(ctx, &order)
+	}
+
+	// Keep the order open until its returns are done, so that it shows how
+	// they ended.
+	return workflow.Await(ctx, func() bool {
+		return pendingReturns == 0 && workflow.AllHandlersFinished(ctx)
+	})
+}
+
+// startOrder hands a checked out cart over to its OrderWorkflow. The order
+// outlives the cart, so the cart only waits for it to start.
+func startOrder(ctx workflow.Context, state *CartState) error {
+	cwo := workflow.ChildWorkflowOptions{
+		WorkflowID:        state.Order.ID,
+		ParentClosePolicy: enums.PARENT_CLOSE_POLICY_ABANDON,
+	}
+	ctx = workflow.WithChildOptions(ctx, cwo)
+
+	var execution workflow.Execution
+	err := workflow.ExecuteChildWorkflow(ctx, OrderWorkflow, state.Order).GetChildWorkflowExecution().Get(ctx, &execution)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Unable to start order", "OrderID", state.Order.ID, "Error", err)
+		return err
+	}
+
+	// Updates that arrive from now on are rejected since the cart is closed.
+	return workflow.Await(ctx, func() bool {
+		return workflow.AllHandlersFinished(ctx)
+	})
+}
+
+// settlePayment holds the authorized payment until fulfillment is confirmed
+// and then captures it. If fulfillment is rejected, the customer cancels, or
+// fulfillment is not confirmed before the authorization expires, the order is
+// cancelled instead. refunds is held while a cancelled order is refunded.
+func settlePayment(ctx workflow.Context, order *Order, refunds workflow.Mutex) error {
+	logger := workflow.GetLogger(ctx)
+	fulfillmentChannel := workflow.GetSignalChannel(ctx, SignalChannels.FULFILLMENT_CHANNEL)
+	cancelChannel := workflow.GetSignalChannel(ctx, SignalChannels.CANCEL_ORDER_CHANNEL)
+	holdExpired := workflow.NewTimer(ctx, untilElapsed(ctx, order.PlacedAt, authorizationHoldTimeout))
+	decided := false
+	confirmed := false
+	reason := ""
+	var shipment Shipment
+
+	for !decided {
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(fulfillmentChannel, func(c workflow.ReceiveChannel, _ bool) {
+			var signal interface{}
+			c.Receive(ctx, &signal)
+
+			var message FulfillmentSignal
+			err := mapstructure.Decode(signal, &message)
+			if err != nil {
+				logger.Error("Invalid signal type %v", err)
+				return
+			}
+
+			decided = true
+			confirmed = message.Confirmed
+			shipment = Shipment{Carrier: message.Carrier, TrackingNumber: message.TrackingNumber}
+			if !confirmed {
+				reason = "fulfillment was rejected"
+			}
+		})
+
+		selector.AddReceive(cancelChannel, func(c workflow.ReceiveChannel, _ bool) {
+			var signal interface{}
+			c.Receive(ctx, &signal)
+
+			decided = true
+			reason = cancelReason(signal)
+		})
+
+		selector.AddFuture(holdExpired, func(f workflow.Future) {
+			logger.Info("Payment authorization expired before fulfillment was confirmed")
+			decided = true
+			reason = "payment authorization expired"
+		})
+
+		selector.Select(ctx)
+	}
+
+	if !confirmed {
+		return cancelOrder(ctx, order, reason, refunds)
+	}
+
+	var a *Activities
+	var payment Payment
+	err := workflow.ExecuteActivity(ctx, a.CapturePayment, order.PaymentID, orderPaymentKey(order, "capture")).Get(ctx, &payment)
+	if err != nil {
+		logger.Error("Error capturing payment %v", err)
+		return err
+	}
+	order.PaymentStatus = payment.Status
+	order.record(LedgerEntry{
+		Type:      LedgerCharge,
+		Amount:    payment.Amount,
+		At:        workflow.Now(ctx),
+		Reference: orderPaymentKey(order, "capture"),
+	})
+
+	// A cancellation that arrived while the payment was being captured still
+	// counts, since nothing has shipped. The payment is refunded instead.
+	var signal interface{}
+	if cancelChannel.ReceiveAsync(&signal) {
+		return cancelOrder(ctx, order, cancelReason(signal), refunds)
+	}
+
+	// Sold units leave stock for good.
+	err = workflow.ExecuteActivity(ctx, a.CommitInventory, order.CartID).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Error updating inventory %v", err)
+		return err
+	}
+
+	shipment.ShippedAt = workflow.Now(ctx)
+	order.Status = OrderShipped
+	order.Shipment = shipment
+	err = workflow.ExecuteActivity(ctx, a.SendShippingNotificationEmail, *order).Get(ctx, nil)
+	if err != nil {
+		// The order has shipped and been paid for either way.
+		logger.Error("Error sending shipping notification", "Error", err)
+	}
+
+	return nil
+}
+
+// cancelOrder voids the order's payment, or refunds it in full if it was
+// already captured, and puts the units back on sale. The full refund holds
+// refunds like any other, so a refund update waits for it and is checked
+// against what it left.
+func cancelOrder(ctx workflow.Context, order *Order, reason string, refunds workflow.Mutex) error {
+	logger := workflow.GetLogger(ctx)
+
+	var a *Activities
+	switch order.PaymentStatus {
+	case PaymentAuthorized:
+		var payment Payment
+		err := workflow.ExecuteActivity(ctx, a.CancelPayment, order.PaymentID, orderPaymentKey(order, "cancel")).Get(ctx, &payment)
+		if err != nil {
+			logger.Error("Error cancelling payment %v", err)
+			return err
+		}
+		order.PaymentStatus = payment.Status
+	case PaymentCaptured:
+		if err := refunds.Lock(ctx); err != nil {
+			return err
+		}
+		refund := LedgerEntry{
+			Type:      LedgerRefund,
+			Amount:    order.refundable(),
+			Reference: orderPaymentKey(order, "refund"),
+			Reason:    reason,
+		}
+		err := refundPayment(ctx, order, refund)
+		refunds.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := workflow.ExecuteActivity(ctx, a.ReleaseInventory, order.CartID).Get(ctx, nil)
+	if err != nil {
+		logger.Error("Error updating inventory %v", err)
+		return err
+	}
+
+	order.Status = OrderCancelled
+	order.CancelledAt = workflow.Now(ctx)
+	order.CancelReason = reason
+	err = workflow.ExecuteActivity(ctx, a.SendOrderCancellationEmail, *order).Get(ctx, nil)
+	if err != nil {
+		// The order is cancelled either way.
+		logger.Error("Error sending cancellation email", "Error", err)
+	}
+
+	return nil
+}
+
+// refundPayment refunds refund.Amount of the order's payment and records it
+// in the ledger. refund.Reference is the idempotency key.
+func refundPayment(ctx workflow.Context, order *Order, refund LedgerEntry) error {
+	var a *Activities
+	var payment Payment
+	err := workflow.ExecuteActivity(ctx, a.RefundPayment, order.PaymentID, refund.Amount, refund.Reference).Get(ctx, &payment)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Error refunding payment %v", err)
+		return err
+	}
+
+	order.PaymentStatus = payment.Status
+	refund.At = workflow.Now(ctx)
+	order.record(refund)
+	return nil
+}
+
+// cancelReason reads the reason out of a CancelOrderSignal.
+func cancelReason(signal interface{}) string {
+	var message CancelOrderSignal
+	if err := mapstructure.Decode(signal, &message); err != nil || message.Reason == "" {
+		return "cancelled by customer"
+	}
+	return message.Reason
+}
+
+// awaitDelivery waits for the carrier to deliver a shipped order. It is too
+// late to cancel the order by then.
+func awaitDelivery(ctx workflow.Context, order *Order) {
+	logger := workflow.GetLogger(ctx)
+	deliveryChannel := workflow.GetSignalChannel(ctx, SignalChannels.DELIVERY_CHANNEL)
+	cancelChannel := workflow.GetSignalChannel(ctx, SignalChannels.CANCEL_ORDER_CHANNEL)
+	timeout := workflow.NewTimer(ctx, untilElapsed(ctx, order.Shipment.ShippedAt, deliveryTimeout))
+	done := false
+
+	for !done {
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(deliveryChannel, func(c workflow.ReceiveChannel, _ bool) {
+			var signal interface{}
+			c.Receive(ctx, &signal)
+
+			order.Status = OrderDelivered
+			order.Shipment.DeliveredAt = workflow.Now(ctx)
+			done = true
+		})
+		selector.AddReceive(cancelChannel, func(c workflow.ReceiveChannel, _ bool) {
+			var signal interface{}
+			c.Receive(ctx, &signal)
+
+			order.reject(ctx, RouteTypes.CANCEL_ORDER, ErrOrderShipped)
+		})
+		selector.AddFuture(timeout, func(f workflow.Future) {
+			logger.Info("Order was never confirmed delivered", "OrderID", order.ID)
+			done = true
+		})
+		selector.Select(ctx)
+	}
+}
+
+// awaitReturnWindow keeps a delivered order open while items can be
+// returned. Cancelling is no longer possible.
+func awaitReturnWindow(ctx workflow.Context, order *Order) {
+	cancelChannel := workflow.GetSignalChannel(ctx, SignalChannels.CANCEL_ORDER_CHANNEL)
+	closed := workflow.NewTimer(ctx, untilElapsed(ctx, order.Shipment.DeliveredAt, returnWindow))
+	done := false
+
+	for !done {
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(cancelChannel, func(c workflow.ReceiveChannel, _ bool) {
+			var signal interface{}
+			c.Receive(ctx, &signal)
+
+			order.reject(ctx, RouteTypes.CANCEL_ORDER, ErrOrderShipped)
+		})
+		selector.AddFuture(closed, func(f workflow.Future) {
+			done = true
+		})
+		selector.Select(ctx)
+	}
+}
+
+// untilElapsed returns how long is left until d has passed since start.
+func untilElapsed(ctx workflow.Context, start time.Time, d time.Duration) time.Duration {
+	wait := start.Add(d).Sub(workflow.Now(ctx))
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// orderPaymentKey derives the idempotency key for a payment operation on an
+// order. Every retry of the activity sends the same key.
+func orderPaymentKey(order *Order, operation string) string {
+	return fmt.Sprintf("%s/%s", order.ID, operation)
+}
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// PaymentProvider is the payment backend used by Activities.
+//
+// Every call carries an idempotency key. Repeating a call with the same key
+// must not repeat its side effect, so activity retries are always safe.
+type PaymentProvider interface {
+	// Authorize places a hold on the customer's payment method without
+	// moving any money.
+	Authorize(ctx context.Context, req PaymentRequest) (Payment, error)
+	// Capture collects the funds of a previously authorized payment.
+	Capture(ctx context.Context, paymentID string, idempotencyKey string) (Payment, error)
+	// Void releases an authorization that has not been captured.
+	Void(ctx context.Context, paymentID string, idempotencyKey string) (Payment, error)
+	// Refund returns some or all of a captured payment to the customer.
+	Refund(ctx context.Context, paymentID string, amount Money, idempotencyKey string) (Payment, error)
+}
+
+type PaymentStatus string
+
+const (
+	PaymentAuthorized PaymentStatus = "authorized"
+	PaymentCaptured   PaymentStatus = "captured"
+	PaymentVoided     PaymentStatus = "voided"
+	PaymentRefunded   PaymentStatus = "refunded"
+)
+
+type (
+	PaymentRequest struct {
+		Amount         Money
+		Description    string
+		Email          string
+		IdempotencyKey string
+	}
+
+	Payment struct {
+		ID       string
+		Amount   Money
+		Status   PaymentStatus
+		Refunded Money
+	}
+)
+
+var (
+	// ErrPaymentDeclined means the customer's payment method was refused.
+	// Retrying will not help.
+	ErrPaymentDeclined       = errors.New("payment declined")
+	ErrPaymentNotFound       = errors.New("payment not found")
+	ErrIdempotencyKeyMissing = errors.New("idempotency key is required")
+	ErrIdempotencyKeyUsed    = errors.New("idempotency key was already used with different parameters")
+	// ErrRefundExceedsCapture means a refund would give back more than is
+	// left of the captured amount.
+	ErrRefundExceedsCapture = errors.New("refund exceeds the captured amount")
+)
+
+// FakePaymentProvider is an in-memory PaymentProvider for tests and local
+// development. It never talks to the network.
+type FakePaymentProvider struct {
+	// FailWith, if set, is called before every operation ("authorize",
+	// "capture", "void" or "refund"). A non-nil result is returned to the
+	// caller instead of performing the operation, which lets tests simulate
+	// declines and outages.
+	FailWith func(op string) error
+	// FailAfter is like FailWith, but is called after the operation has
+	// been applied. It simulates a response lost to a timeout.
+	FailAfter func(op string) error
+
+	mu       sync.Mutex
+	payments map[string]*Payment
+	order    []string
+	keys     map[string]fakeIdempotentCall
+}
+
+// fakeIdempotentCall remembers the outcome of a call so that a retry with the
+// same idempotency key replays it instead of running the operation again.
+type fakeIdempotentCall struct {
+	request string
+	payment Payment
+}
+
+func NewFakePaymentProvider() *FakePaymentProvider {
+	return &FakePaymentProvider{
+		payments: make(map[string]*Payment),
+		keys:     make(map[string]fakeIdempotentCall),
+	}
+}
+
+func (p *FakePaymentProvider) Authorize(_ context.Context, req PaymentRequest) (Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.fail("authorize"); err != nil {
+		return Payment{}, err
+	}
+	if payment, ok, err := p.replay(req.IdempotencyKey, fmt.Sprintf("authorize %+v", req)); ok {
+		return payment, err
+	}
+	if req.Amount.Amount <= 0 {
+		return Payment{}, fmt.Errorf("invalid amount %s", req.Amount)
+	}
+
+	payment := &Payment{
+		ID:       fmt.Sprintf("fake_%d", len(p.order)+1),
+		Amount:   req.Amount,
+		Status:   PaymentAuthorized,
+		Refunded: Money{Currency: req.Amount.Currency},
+	}
+	p.payments[payment.ID] = payment
+	p.order = append(p.order, payment.ID)
+
+	return p.record("authorize", req.IdempotencyKey, fmt.Sprintf("authorize %+v", req), *payment)
+}
+
+func (p *FakePaymentProvider) Capture(_ context.Context, paymentID string, idempotencyKey string) (Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.fail("capture"); err != nil {
+		return Payment{}, err
+	}
+	if payment, ok, err := p.replay(idempotencyKey, "capture "+paymentID); ok {
+		return payment, err
+	}
+	payment, ok := p.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrPaymentNotFound
+	}
+	if payment.Status != PaymentAuthorized {
+		return Payment{}, fmt.Errorf("cannot capture %s payment", payment.Status)
+	}
+
+	payment.Status = PaymentCaptured
+	return p.record("capture", idempotencyKey, "capture "+paymentID, *payment)
+}
+
+func (p *FakePaymentProvider) Void(_ context.Context, paymentID string, idempotencyKey string) (Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.fail("void"); err != nil {
+		return Payment{}, err
+	}
+	if payment, ok, err := p.replay(idempotencyKey, "void "+paymentID); ok {
+		return payment, err
+	}
+	payment, ok := p.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrPaymentNotFound
+	}
+	if payment.Status != PaymentAuthorized {
+		return Payment{}, fmt.Errorf("cannot void %s payment", payment.Status)
+	}
+
+	payment.Status = PaymentVoided
+	return p.record("void", idempotencyKey, "void "+paymentID, *payment)
+}
+
+func (p *FakePaymentProvider) Refund(_ context.Context, paymentID string, amount Money, idempotencyKey string) (Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.fail("refund"); err != nil {
+		return Payment{}, err
+	}
+	request := fmt.Sprintf("refund %s %s", paymentID, amount)
+	if payment, ok, err := p.replay(idempotencyKey, request); ok {
+		return payment, err
+	}
+	payment, ok := p.payments[paymentID]
+	if !ok {
+		return Payment{}, ErrPaymentNotFound
+	}
+	if payment.Status != PaymentCaptured {
+		return Payment{}, fmt.Errorf("cannot refund %s payment", payment.Status)
+	}
+	refunded, err := payment.Refunded.Add(amount)
+	if err != nil {
+		return Payment{}, err
+	}
+	if amount.Amount <= 0 {
+		return Payment{}, fmt.Errorf("invalid refund amount %s", amount)
+	}
+	if refunded.Amount > payment.Amount.Amount {
+		return Payment{}, fmt.Errorf("%w: %s refunded of %s", ErrRefundExceedsCapture, refunded, payment.Amount)
+	}
+
+	payment.Refunded = refunded
+	if payment.Refunded == payment.Amount {
+		payment.Status = PaymentRefunded
+	}
+	return p.record("refund", idempotencyKey, request, *payment)
+}
+
+// Payments returns every payment the provider has seen, oldest first.
+func (p *FakePaymentProvider) Payments() []Payment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payments := make([]Payment, 0, len(p.order))
+	for _, id := range p.order {
+		payments = append(payments, *p.payments[id])
+	}
+	return payments
+}
+
+func (p *FakePaymentProvider) fail(op string) error {
+	if p.FailWith == nil {
+		return nil
+	}
+	return p.FailWith(op)
+}
+
+// replay returns the recorded outcome for an idempotency key, if any. Reusing
+// a key for a different request is an error, as it is with Stripe. Unlike
+// Stripe, the fake refuses calls without a key so tests catch callers that
+// forget one.
+func (p *FakePaymentProvider) replay(idempotencyKey string, request string) (Payment, bool, error) {
+	if idempotencyKey == "" {
+		return Payment{}, true, ErrIdempotencyKeyMissing
+	}
+	call, ok := p.keys[idempotencyKey]
+	if !ok {
+		return Payment{}, false, nil
+	}
+	if call.request != request {
+		return Payment{}, true, ErrIdempotencyKeyUsed
+	}
+	return call.payment, true, nil
+}
+
+func (p *FakePaymentProvider) record(op string, idempotencyKey string, request string, payment Payment) (Payment, error) {
+	p.keys[idempotencyKey] = fakeIdempotentCall{request: request, payment: payment}
+	if p.FailAfter != nil {
+		if err := p.FailAfter(op); err != nil {
+			return Payment{}, err
+		}
+	}
+	return payment, nil
+}
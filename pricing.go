@@ -0,0 +1,135 @@
+package app
+
+import "fmt"
+
+type (
+	// PricedLine is one cart item with its price in the cart's currency.
+	PricedLine struct {
+		SKU       string
+		ProductId int
+		// Name is the variant's, such as "iPhone 12 Pro 256GB Graphite".
+		Name      string
+		Image     string
+		Quantity  int
+		UnitPrice Money
+		Subtotal  Money
+		Discount  Money
+		Total     Money
+	}
+
+	// Pricing is the full price breakdown of a cart. The workflow recomputes
+	// it on every change, and checkout charges exactly Pricing.Total.
+	Pricing struct {
+		Lines    []PricedLine
+		Subtotal Money
+		Discount Money
+		Shipping Money
+		Tax      Money
+		Total    Money
+	}
+
+	// ShippingRate is a flat shipping fee, waived once the discounted
+	// subtotal reaches FreeOver.
+	ShippingRate struct {
+		Fee      Money
+		FreeOver Money
+	}
+
+	PricingRules struct {
+		// TaxRates are in basis points per currency, so 2000 is 20%.
+		TaxRates      map[string]int64
+		ShippingRates map[string]ShippingRate
+	}
+)
+
+var DefaultPricingRules = PricingRules{
+	TaxRates: map[string]int64{
+		"USD": 725,
+		"EUR": 2000,
+		"GBP": 2000,
+		"JPY": 1000,
+	},
+	ShippingRates: map[string]ShippingRate{
+		"USD": {Fee: Money{Amount: 999, Currency: "USD"}, FreeOver: Money{Amount: 100000, Currency: "USD"}},
+		"EUR": {Fee: Money{Amount: 999, Currency: "EUR"}, FreeOver: Money{Amount: 100000, Currency: "EUR"}},
+		"GBP": {Fee: Money{Amount: 899, Currency: "GBP"}, FreeOver: Money{Amount: 90000, Currency: "GBP"}},
+		"JPY": {Fee: Money{Amount: 1200, Currency: "JPY"}, FreeOver: Money{Amount: 120000, Currency: "JPY"}},
+	},
+}
+
+// PriceCart computes the price breakdown of a cart. It is a pure function of
+// its inputs, so it is safe to call from workflow code. Items are priced from
+// the variants the cart keeps in state.Variants.
+func PriceCart(state CartState, rules PricingRules) (Pricing, error) {
+	currency := state.Currency
+	zero := Money{Currency: currency}
+	pricing := Pricing{
+		Lines:    make([]PricedLine, 0, len(state.Items)),
+		Subtotal: zero,
+		Discount: zero,
+		Shipping: zero,
+		Tax:      zero,
+		Total:    zero,
+	}
+
+	for _, item := range state.Items {
+		variant, ok := state.variant(item.SKU)
+		if !ok {
+			return Pricing{}, fmt.Errorf("unknown SKU %s", item.SKU)
+		}
+		price, ok := variant.Price(currency)
+		if !ok {
+			return Pricing{}, fmt.Errorf("SKU %s is not sold in %s", item.SKU, currency)
+		}
+
+		subtotal := price.Mul(int64(item.Quantity))
+		pricing.Lines = append(pricing.Lines, PricedLine{
+			SKU:       item.SKU,
+			ProductId: variant.ProductId,
+			Name:      variant.Name,
+			Image:     variant.Image,
+			Quantity:  item.Quantity,
+			UnitPrice: price,
+			Subtotal:  subtotal,
+			Discount:  zero,
+		})
+		pricing.Subtotal.Amount += subtotal.Amount
+	}
+
+	freeShipping := false
+	if promotion, ok := FindPromotion(state.CouponCode); ok {
+		freeShipping = promotion.apply(&pricing)
+	}
+
+	for i, line := range pricing.Lines {
+		pricing.Lines[i].Total = Money{Amount: line.Subtotal.Amount - line.Discount.Amount, Currency: currency}
+		pricing.Discount.Amount += line.Discount.Amount
+	}
+
+	discounted, err := pricing.Subtotal.Sub(pricing.Discount)
+	if err != nil {
+		return Pricing{}, err
+	}
+
+	if rate, ok := rules.ShippingRates[currency]; ok && len(pricing.Lines) > 0 && !freeShipping {
+		free, err := discounted.Cmp(rate.FreeOver)
+		if err != nil {
+			return Pricing{}, err
+		}
+		if free < 0 {
+			pricing.Shipping = rate.Fee
+		}
+	}
+
+	pricing.Tax = discounted.BasisPoints(rules.TaxRates[currency])
+
+	pricing.Total = discounted
+	for _, m := range []Money{pricing.Shipping, pricing.Tax} {
+		pricing.Total, err = pricing.Total.Add(m)
+		if err != nil {
+			return Pricing{}, err
+		}
+	}
+
+	return pricing, nil
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriceCart(t *testing.T) {
+	cart := withVariants(CartState{
+		Currency: "USD",
+		Items: []CartItem{
+			{SKU: "IPSE-64-BLK", Quantity: 1},
+			{SKU: "IP11-64-BLK", Quantity: 2},
+		},
+	})
+
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, len(pricing.Lines))
+	assert.Equal(t, "IP11-64-BLK", pricing.Lines[1].SKU)
+	assert.Equal(t, 3, pricing.Lines[1].ProductId)
+	assert.Equal(t, "iPhone 11 64GB Black", pricing.Lines[1].Name)
+	assert.Equal(t, Money{Amount: 59900, Currency: "USD"}, pricing.Lines[1].UnitPrice)
+	assert.Equal(t, Money{Amount: 119800, Currency: "USD"}, pricing.Lines[1].Total)
+
+	assert.Equal(t, Money{Amount: 159700, Currency: "USD"}, pricing.Subtotal)
+	assert.Equal(t, Money{Amount: 0, Currency: "USD"}, pricing.Shipping)
+	// 7.25% of 1597.00 is 115.7825
+	assert.Equal(t, Money{Amount: 11578, Currency: "USD"}, pricing.Tax)
+	assert.Equal(t, Money{Amount: 171278, Currency: "USD"}, pricing.Total)
+}
+
+func TestPriceEmptyCart(t *testing.T) {
+	pricing, err := PriceCart(CartState{Currency: "GBP"}, DefaultPricingRules)
+	require.NoError(t, err)
+
+	assert.Equal(t, 0, len(pricing.Lines))
+	assert.Equal(t, Money{Currency: "GBP"}, pricing.Shipping)
+	assert.Equal(t, Money{Currency: "GBP"}, pricing.Total)
+}
+
+func TestPriceCartUnknownSKU(t *testing.T) {
+	_, err := PriceCart(CartState{Currency: "USD", Items: []CartItem{{SKU: "PIXEL-9-128", Quantity: 1}}}, DefaultPricingRules)
+	assert.Error(t, err)
+}
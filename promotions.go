@@ -0,0 +1,182 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type PromotionType string
+
+const (
+	PromotionPercentOff   PromotionType = "percent_off"
+	PromotionAmountOff    PromotionType = "amount_off"
+	PromotionBuyXGetY     PromotionType = "buy_x_get_y"
+	PromotionFreeShipping PromotionType = "free_shipping"
+)
+
+// Promotion is a discount unlocked by a coupon code. Only the fields for its
+// Type are used.
+type Promotion struct {
+	Code string
+	Type PromotionType
+
+	// PercentOff is in basis points, so 1000 is 10% off every line.
+	PercentOff int64
+	// AmountOff is taken off the order, once, in the cart's currency.
+	AmountOff []Money
+	// Buying BuyQuantity units of ProductId gets GetQuantity more for free.
+	// Each variant of the product is counted on its own.
+	ProductId   int
+	BuyQuantity int
+	GetQuantity int
+
+	// MinimumOrder is the subtotal the cart must reach, per currency.
+	MinimumOrder []Money
+	// ExpiresAt is when the code stops working. Zero means never.
+	ExpiresAt time.Time
+}
+
+var (
+	ErrCouponNotFound      = errors.New("coupon code not found")
+	ErrCouponExpired       = errors.New("coupon code has expired")
+	ErrCouponMinimumNotMet = errors.New("order does not reach the coupon's minimum value")
+)
+
+var Promotions = []Promotion{
+	{
+		Code:       "SAVE10",
+		Type:       PromotionPercentOff,
+		PercentOff: 1000,
+		ExpiresAt:  time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		Code: "TAKE50",
+		Type: PromotionAmountOff,
+		AmountOff: []Money{
+			{Amount: 5000, Currency: "USD"},
+			{Amount: 5000, Currency: "EUR"},
+			{Amount: 4500, Currency: "GBP"},
+			{Amount: 7000, Currency: "JPY"},
+		},
+		MinimumOrder: []Money{
+			{Amount: 100000, Currency: "USD"},
+			{Amount: 100000, Currency: "EUR"},
+			{Amount: 90000, Currency: "GBP"},
+			{Amount: 140000, Currency: "JPY"},
+		},
+		ExpiresAt: time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		Code:        "SE2FOR1",
+		Type:        PromotionBuyXGetY,
+		ProductId:   2,
+		BuyQuantity: 1,
+		GetQuantity: 1,
+		ExpiresAt:   time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		Code: "FREESHIP",
+		Type: PromotionFreeShipping,
+	},
+	{
+		Code:       "LAUNCH2020",
+		Type:       PromotionPercentOff,
+		PercentOff: 2000,
+		ExpiresAt:  time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+	},
+}
+
+// FindPromotion looks up a coupon code, ignoring case and surrounding space.
+func FindPromotion(code string) (Promotion, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, promotion := range Promotions {
+		if promotion.Code == code {
+			return promotion, true
+		}
+	}
+	return Promotion{}, false
+}
+
+// CheckCoupon reports whether a coupon code can be applied to the cart at the
+// given time. Workflows must pass workflow.Now.
+func CheckCoupon(code string, state CartState, now time.Time) (Promotion, error) {
+	promotion, ok := FindPromotion(code)
+	if !ok {
+		return Promotion{}, fmt.Errorf("%w: %q", ErrCouponNotFound, code)
+	}
+	if !promotion.ExpiresAt.IsZero() && !now.Before(promotion.ExpiresAt) {
+		return Promotion{}, fmt.Errorf("%w: %s expired on %s", ErrCouponExpired, promotion.Code, promotion.ExpiresAt.Format("2006-01-02"))
+	}
+	if !promotion.meetsMinimum(state.Pricing.Subtotal) {
+		minimum, _ := priceIn(promotion.MinimumOrder, state.Currency)
+		return Promotion{}, fmt.Errorf("%w: %s requires %s", ErrCouponMinimumNotMet, promotion.Code, minimum)
+	}
+
+	return promotion, nil
+}
+
+func (p Promotion) meetsMinimum(subtotal Money) bool {
+	if len(p.MinimumOrder) == 0 {
+		return true
+	}
+	minimum, ok := priceIn(p.MinimumOrder, subtotal.Currency)
+	return ok && subtotal.Amount >= minimum.Amount
+}
+
+// spread divides an order discount over the lines in proportion to their
+// subtotals, so that each line's Total is what was paid for it. Shares are
+// rounded down and what is left over is handed out a minor unit at a time.
+func spread(pricing *Pricing, discount Money) {
+	if pricing.Subtotal.Amount <= 0 {
+		return
+	}
+	remaining := discount.Amount
+	for i, line := range pricing.Lines {
+		pricing.Lines[i].Discount = discount.Share(line.Subtotal.Amount, pricing.Subtotal.Amount)
+		remaining -= pricing.Lines[i].Discount.Amount
+	}
+	for i := 0; remaining > 0; i = (i + 1) % len(pricing.Lines) {
+		if line := &pricing.Lines[i]; line.Discount.Amount < line.Subtotal.Amount {
+			line.Discount.Amount++
+			remaining--
+		}
+	}
+}
+
+// apply sets the promotion's line and order discounts on a priced cart and
+// reports whether shipping is free.
+func (p Promotion) apply(pricing *Pricing) bool {
+	if !p.meetsMinimum(pricing.Subtotal) {
+		return false
+	}
+
+	switch p.Type {
+	case PromotionPercentOff:
+		for i := range pricing.Lines {
+			pricing.Lines[i].Discount = pricing.Lines[i].Subtotal.BasisPoints(p.PercentOff)
+		}
+	case PromotionBuyXGetY:
+		for i, line := range pricing.Lines {
+			if line.ProductId != p.ProductId || p.BuyQuantity+p.GetQuantity <= 0 {
+				continue
+			}
+			free := line.Quantity / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+			pricing.Lines[i].Discount = line.UnitPrice.Mul(int64(free))
+		}
+	case PromotionAmountOff:
+		amount, ok := priceIn(p.AmountOff, pricing.Subtotal.Currency)
+		if !ok {
+			return false
+		}
+		if amount.Amount > pricing.Subtotal.Amount {
+			amount = pricing.Subtotal
+		}
+		spread(pricing, amount)
+	case PromotionFreeShipping:
+		return true
+	}
+
+	return false
+}
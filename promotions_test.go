@@ -0,0 +1,88 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var promotionTestTime = time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+func priceWithCoupon(t *testing.T, code string, items ...CartItem) Pricing {
+	cart := withVariants(CartState{Currency: "USD", Items: items})
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+	cart.Pricing = pricing
+
+	promotion, err := CheckCoupon(code, cart, promotionTestTime)
+	require.NoError(t, err)
+	cart.CouponCode = promotion.Code
+
+	pricing, err = PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+	return pricing
+}
+
+func TestPercentOffCoupon(t *testing.T) {
+	pricing := priceWithCoupon(t, "save10", CartItem{SKU: "IP12-64-BLK", Quantity: 1})
+
+	assert.Equal(t, Money{Amount: 6990, Currency: "USD"}, pricing.Lines[0].Discount)
+	assert.Equal(t, Money{Amount: 62910, Currency: "USD"}, pricing.Lines[0].Total)
+	assert.Equal(t, Money{Amount: 6990, Currency: "USD"}, pricing.Discount)
+	// Tax is charged on the discounted subtotal
+	assert.Equal(t, Money{Amount: 4561, Currency: "USD"}, pricing.Tax)
+}
+
+func TestAmountOffCoupon(t *testing.T) {
+	pricing := priceWithCoupon(t, "TAKE50", CartItem{SKU: "IP12PRO-128-GRA", Quantity: 2})
+
+	assert.Equal(t, Money{Amount: 5000, Currency: "USD"}, pricing.Discount)
+	// The discount comes off the lines, so each says what was paid for it
+	assert.Equal(t, Money{Amount: 5000, Currency: "USD"}, pricing.Lines[0].Discount)
+	assert.Equal(t, Money{Amount: 194800, Currency: "USD"}, pricing.Lines[0].Total)
+
+	// Spread over several lines by their subtotals, without losing a cent
+	pricing = priceWithCoupon(t, "TAKE50",
+		CartItem{SKU: "IP12PRO-128-GRA", Quantity: 1},
+		CartItem{SKU: "IPSE-64-BLK", Quantity: 1},
+		CartItem{SKU: "IP11-64-BLK", Quantity: 1})
+	assert.Equal(t, Money{Amount: 5000, Currency: "USD"}, pricing.Discount)
+	assert.Equal(t, Money{Amount: 2502, Currency: "USD"}, pricing.Lines[0].Discount)
+	assert.Equal(t, Money{Amount: 999, Currency: "USD"}, pricing.Lines[1].Discount)
+	assert.Equal(t, Money{Amount: 1499, Currency: "USD"}, pricing.Lines[2].Discount)
+}
+
+func TestBuyXGetYCoupon(t *testing.T) {
+	pricing := priceWithCoupon(t, "SE2FOR1",
+		CartItem{SKU: "IPSE-64-BLK", Quantity: 3},
+		CartItem{SKU: "IP12-64-BLK", Quantity: 1})
+
+	// Three iPhone SEs on a buy one get one free deal: one of them is free
+	assert.Equal(t, Money{Amount: 39900, Currency: "USD"}, pricing.Lines[0].Discount)
+	assert.Equal(t, Money{Amount: 0, Currency: "USD"}, pricing.Lines[1].Discount)
+}
+
+func TestFreeShippingCoupon(t *testing.T) {
+	pricing := priceWithCoupon(t, "FREESHIP", CartItem{SKU: "IPSE-64-BLK", Quantity: 1})
+
+	assert.Equal(t, Money{Amount: 0, Currency: "USD"}, pricing.Shipping)
+	assert.Equal(t, Money{Amount: 0, Currency: "USD"}, pricing.Discount)
+}
+
+func TestCheckCouponRejections(t *testing.T) {
+	cart := withVariants(CartState{Currency: "USD", Items: []CartItem{{SKU: "IPSE-64-BLK", Quantity: 1}}})
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+	cart.Pricing = pricing
+
+	_, err = CheckCoupon("NOPE", cart, promotionTestTime)
+	assert.ErrorIs(t, err, ErrCouponNotFound)
+
+	_, err = CheckCoupon("LAUNCH2020", cart, promotionTestTime)
+	assert.ErrorIs(t, err, ErrCouponExpired)
+
+	_, err = CheckCoupon("TAKE50", cart, promotionTestTime)
+	assert.ErrorIs(t, err, ErrCouponMinimumNotMet)
+}
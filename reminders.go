@@ -0,0 +1,43 @@
+package app
+
+import "time"
+
+type ReminderStage string
+
+const (
+	ReminderFirst    ReminderStage = "reminder"
+	ReminderDiscount ReminderStage = "discount"
+	ReminderFinal    ReminderStage = "final"
+)
+
+// ReminderStep is one email of the abandoned cart campaign. After is measured
+// from the moment the cart counts as abandoned, AbandonedCartTimeout after its
+// last change.
+type ReminderStep struct {
+	After time.Duration
+	Stage ReminderStage
+	// CouponCode is offered in the email, if set.
+	CouponCode string
+}
+
+// AbandonedCartReminders is the default campaign sent to a cart that has been
+// left alone. Any change to the cart cancels the remaining steps and starts the
+// campaign over once the cart is abandoned again. With a one hour
+// AbandonedCartTimeout, the emails go out 1, 24 and 72 hours after the last
+// change.
+var AbandonedCartReminders = []ReminderStep{
+	{After: 0, Stage: ReminderFirst},
+	{After: 23 * time.Hour, Stage: ReminderDiscount, CouponCode: "SAVE10"},
+	{After: 71 * time.Hour, Stage: ReminderFinal},
+}
+
+// AbandonedCartReminder is everything needed to write one reminder email.
+type AbandonedCartReminder struct {
+	CartID     string
+	Email      string
+	Locale     string
+	Step       int
+	Stage      ReminderStage
+	CouponCode string
+	Pricing    Pricing
+}
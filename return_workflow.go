@@ -0,0 +1,202 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"go.temporal.io/sdk/workflow"
+)
+
+var (
+	// Items can be returned for this long after the order is delivered.
+	returnWindow = 30 * 24 * time.Hour
+
+	// A return nobody has decided on by then is approved, since it was
+	// requested inside the return window.
+	returnApprovalTimeout = 2 * 24 * time.Hour
+
+	// A return that has not arrived this long after its label was issued
+	// expires, and nothing is refunded.
+	returnShippingTimeout = 14 * 24 * time.Hour
+)
+
+// ReturnWorkflow takes a return from request to refund: it waits for the
+// return to be approved, issues a prepaid label, waits for the items to
+// arrive and be inspected, then refunds and restocks what passed. It is
+// started by the order's OrderWorkflow and returns the final Return.
+func ReturnWorkflow(ctx workflow.Context, ret Return) (Return, error) {
+	logger := workflow.GetLogger(ctx)
+
+	err := workflow.SetQueryHandler(ctx, "getReturn", func(input []byte) (Return, error) {
+		return ret, nil
+	})
+	if err != nil {
+		logger.Info("SetQueryHandler failed.", "Error", err)
+		return ret, err
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	approved, note := awaitReturnApproval(ctx, &ret)
+	ret.DecidedAt = workflow.Now(ctx)
+	if !approved {
+		ret.Status = ReturnRejected
+		ret.Note = note
+		return ret, nil
+	}
+
+	var a *Activities
+	err = workflow.ExecuteActivity(ctx, a.IssueReturnLabel, ret.ID).Get(ctx, &ret.Label)
+	if err != nil {
+		logger.Error("Error issuing return label %v", err)
+		return ret, err
+	}
+	ret.Label.IssuedAt = workflow.Now(ctx)
+	ret.Status = ReturnLabelIssued
+
+	accepted, received := awaitReturnReceipt(ctx, &ret)
+	if !received {
+		ret.Status = ReturnExpired
+		return ret, nil
+	}
+	ret.Accepted = accepted
+	ret.ReceivedAt = workflow.Now(ctx)
+	ret.Status = ReturnReceived
+	if len(accepted) == 0 {
+		ret.Status = ReturnRejected
+		ret.Note = "no items passed inspection"
+		return ret, nil
+	}
+
+	refund, err := ReturnRefund(ret.OrderPricing, accepted)
+	if err != nil {
+		logger.Error("Error pricing refund %v", err)
+		return ret, err
+	}
+	var payment Payment
+	err = workflow.ExecuteActivity(ctx, a.RefundPayment, ret.PaymentID, refund, ret.ID+"/refund").Get(ctx, &payment)
+	if err != nil {
+		logger.Error("Error refunding return %v", err)
+		return ret, err
+	}
+	ret.Refund = refund
+	ret.RefundedAt = workflow.Now(ctx)
+
+	for _, item := range accepted {
+		err = workflow.ExecuteActivity(ctx, a.RestockInventory, ret.ID, item.SKU, item.Quantity).Get(ctx, nil)
+		if err != nil {
+			logger.Error("Error restocking inventory %v", err)
+			return ret, err
+		}
+	}
+
+	ret.Status = ReturnRefunded
+	return ret, nil
+}
+
+// awaitReturnApproval waits for the return to be approved or rejected, and
+// approves it if nobody decides in time.
+func awaitReturnApproval(ctx workflow.Context, ret *Return) (bool, string) {
+	logger := workflow.GetLogger(ctx)
+	approvalChannel := workflow.GetSignalChannel(ctx, SignalChannels.RETURN_APPROVAL_CHANNEL)
+	timeout := workflow.NewTimer(ctx, untilElapsed(ctx, ret.RequestedAt, returnApprovalTimeout))
+	decided := false
+	approved := false
+	note := ""
+
+	for !decided {
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(approvalChannel, func(c workflow.ReceiveChannel, _ bool) {
+			var signal interface{}
+			c.Receive(ctx, &signal)
+
+			var message ReturnApprovalSignal
+			err := mapstructure.Decode(signal, &message)
+			if err != nil {
+				logger.Error("Invalid signal type %v", err)
+				return
+			}
+
+			decided = true
+			approved = message.Approved
+			note = message.Reason
+		})
+		selector.AddFuture(timeout, func(f workflow.Future) {
+			logger.Info("Return was approved automatically", "ReturnID", ret.ID)
+			decided = true
+			approved = true
+		})
+		selector.Select(ctx)
+	}
+
+	return approved, note
+}
+
+// awaitReturnReceipt waits for the items to arrive, and returns the ones
+// that passed inspection. It reports false if they never arrived.
+func awaitReturnReceipt(ctx workflow.Context, ret *Return) ([]CartItem, bool) {
+	logger := workflow.GetLogger(ctx)
+	receiptChannel := workflow.GetSignalChannel(ctx, SignalChannels.RETURN_RECEIPT_CHANNEL)
+	timeout := workflow.NewTimer(ctx, untilElapsed(ctx, ret.Label.IssuedAt, returnShippingTimeout))
+	var accepted []CartItem
+	received := false
+	done := false
+
+	for !done {
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(receiptChannel, func(c workflow.ReceiveChannel, _ bool) {
+			var signal interface{}
+			c.Receive(ctx, &signal)
+
+			var message ReturnReceiptSignal
+			err := mapstructure.Decode(signal, &message)
+			if err != nil {
+				logger.Error("Invalid signal type %v", err)
+				return
+			}
+			if err := ret.ValidateReceipt(message.Accepted); err != nil {
+				logger.Error("Invalid return receipt", "ReturnID", ret.ID, "Error", err)
+				return
+			}
+
+			done = true
+			received = true
+			accepted = make([]CartItem, 0, len(message.Accepted))
+			for _, item := range message.Accepted {
+				if item.Quantity > 0 {
+					accepted = append(accepted, item)
+				}
+			}
+		})
+		selector.AddFuture(timeout, func(f workflow.Future) {
+			logger.Info("Return never arrived", "ReturnID", ret.ID)
+			done = true
+		})
+		selector.Select(ctx)
+	}
+
+	return accepted, received
+}
+
+// ValidateReceipt checks that the accepted items were all part of the
+// return.
+func (r Return) ValidateReceipt(accepted []CartItem) error {
+	for _, item := range accepted {
+		if item.Quantity < 0 {
+			return invalidRequest("quantity must not be negative, got %d", item.Quantity)
+		}
+		if got, want := itemQuantity(accepted, item.SKU), itemQuantity(r.Items, item.SKU); got > want {
+			return invalidRequest("%d units of SKU %s were accepted, but only %d were returned", got, item.SKU, want)
+		}
+	}
+	return nil
+}
+
+// returnID names the nth return of an order, counting from 1.
+func returnID(order *Order, n int) string {
+	return fmt.Sprintf("%s-RETURN-%d", order.ID, n)
+}
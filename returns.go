@@ -0,0 +1,176 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+type ReturnStatus string
+
+const (
+	ReturnRequested   ReturnStatus = "requested"
+	ReturnLabelIssued ReturnStatus = "label_issued"
+	ReturnReceived    ReturnStatus = "received"
+	ReturnRefunded    ReturnStatus = "refunded"
+	ReturnRejected    ReturnStatus = "rejected"
+	ReturnExpired     ReturnStatus = "expired"
+)
+
+// ReturnRequest asks to send back some of the items of a delivered order.
+type ReturnRequest struct {
+	Items  []CartItem
+	Reason string
+}
+
+// Return is a return merchandise authorization (RMA): some items of an order
+// on their way back for a refund. Its ReturnWorkflow keeps it up to date, and
+// its ID is the workflow ID.
+type Return struct {
+	ID        string
+	OrderID   string
+	PaymentID string
+	// OrderPricing is what the whole order cost. The refund is worked out
+	// from it.
+	OrderPricing Pricing
+	Items        []CartItem
+	Reason       string
+	Status       ReturnStatus
+	RequestedAt  time.Time
+
+	// Set once the return is approved or rejected. Note says why it was
+	// rejected, if it was.
+	DecidedAt time.Time
+	Note      string
+
+	// Label is the prepaid label the customer ships the items back with.
+	Label ReturnLabel
+
+	// Accepted are the items that passed inspection on receipt. Only these
+	// are refunded and restocked.
+	Accepted   []CartItem
+	ReceivedAt time.Time
+	Refund     Money
+	RefundedAt time.Time
+}
+
+// ReturnLabel is a prepaid shipping label for a return.
+type ReturnLabel struct {
+	Carrier        string
+	TrackingNumber string
+	URL            string
+	IssuedAt       time.Time
+}
+
+// open reports whether the return may still send items back.
+func (r Return) open() bool {
+	return r.Status != ReturnRejected && r.Status != ReturnExpired
+}
+
+// quantity is how many units of a SKU the return covers: what passed
+// inspection once the items are received, what was asked for until then.
+func (r Return) quantity(sku string) int {
+	items := r.Items
+	if r.Accepted != nil {
+		items = r.Accepted
+	}
+	return itemQuantity(items, sku)
+}
+
+func itemQuantity(items []CartItem, sku string) int {
+	quantity := 0
+	for _, item := range items {
+		if item.SKU == sku {
+			quantity += item.Quantity
+		}
+	}
+	return quantity
+}
+
+// unclaimed is how many units of a SKU can still be returned or refunded:
+// those bought, less those already refunded and those in a return that has
+// not been refunded yet. A return refunded nothing has no ledger entry, so
+// its units are counted here.
+func (order *Order) unclaimed(sku string) int {
+	quantity := itemQuantity(order.Items, sku) - itemQuantity(order.RefundedItems, sku)
+	for _, r := range order.Returns {
+		if r.open() && (r.Status != ReturnRefunded || r.Refund.IsZero()) {
+			quantity -= r.quantity(sku)
+		}
+	}
+	return quantity
+}
+
+// validateReturn checks a return request against the order at time now. Like
+// the cart's validators, it must not change the order.
+func (order *Order) validateReturn(request ReturnRequest, now time.Time) error {
+	if order.Status != OrderDelivered {
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("order is %s", order.Status), ErrorTypeOrderStatus, nil)
+	}
+	if closes := order.Shipment.DeliveredAt.Add(returnWindow); now.After(closes) {
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("the return window closed on %s", closes.Format(time.RFC822)), ErrorTypeOrderStatus, nil)
+	}
+	if len(request.Items) == 0 {
+		return invalidRequest("nothing to return")
+	}
+
+	requested := make(map[string]int)
+	for _, item := range request.Items {
+		if item.Quantity <= 0 {
+			return invalidRequest("quantity must be positive, got %d", item.Quantity)
+		}
+		requested[item.SKU] += item.Quantity
+	}
+	for sku, quantity := range requested {
+		if left := order.unclaimed(sku); quantity > left {
+			return invalidRequest("%d units of SKU %s can be returned, not %d", left, sku, quantity)
+		}
+	}
+	return nil
+}
+
+// ReturnRefund is what the customer gets back for returning items of an
+// order: what they paid for those units after discounts, plus the tax on
+// that. Shipping is not refunded.
+func ReturnRefund(pricing Pricing, items []CartItem) (Money, error) {
+	refund := Money{Currency: pricing.Total.Currency}
+	for _, item := range items {
+		line, ok := pricedLine(pricing, item.SKU)
+		if !ok || item.Quantity > line.Quantity {
+			return Money{}, fmt.Errorf("%d units of SKU %s were not bought", item.Quantity, item.SKU)
+		}
+		refund.Amount += line.Total.Share(int64(item.Quantity), int64(line.Quantity)).Amount
+	}
+
+	discounted, err := pricing.Subtotal.Sub(pricing.Discount)
+	if err != nil {
+		return Money{}, err
+	}
+	// Orders priced before amount-off discounts were spread over the lines
+	// have part of their discount on no line. That part comes off the
+	// refund in proportion as well.
+	lines := Money{Currency: pricing.Total.Currency}
+	for _, line := range pricing.Lines {
+		lines.Amount += line.Total.Amount
+	}
+	if unspread := lines.Amount - discounted.Amount; unspread > 0 {
+		// Rounded up, so that partial refunds never add up to more
+		// than was paid.
+		kept := Money{Amount: unspread}.Share(lines.Amount-refund.Amount, lines.Amount)
+		refund.Amount -= unspread - kept.Amount
+	}
+	if discounted.Amount > 0 {
+		refund.Amount += pricing.Tax.Share(refund.Amount, discounted.Amount).Amount
+	}
+	return refund, nil
+}
+
+func pricedLine(pricing Pricing, sku string) (PricedLine, bool) {
+	for _, line := range pricing.Lines {
+		if line.SKU == sku {
+			return line, true
+		}
+	}
+	return PricedLine{}, false
+}
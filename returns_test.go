@@ -0,0 +1,109 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReturnRefund(t *testing.T) {
+	cart := withVariants(CartState{
+		Currency:   "USD",
+		Items:      []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}, {SKU: "IP11-64-BLK", Quantity: 1}},
+		CouponCode: "SAVE10",
+	})
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+
+	// Half of 2 x 699.00 less 10%, plus 7.25% tax on that
+	refund, err := ReturnRefund(pricing, []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}})
+	require.NoError(t, err)
+	assert.Equal(t, Money{Amount: 67470, Currency: "USD"}, refund)
+
+	// Returning everything gives back all but the shipping, give or take
+	// rounding in the customer's disfavour.
+	refund, err = ReturnRefund(pricing, cart.Items)
+	require.NoError(t, err)
+	paid, err := pricing.Total.Sub(pricing.Shipping)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, refund.Amount, paid.Amount)
+	assert.GreaterOrEqual(t, refund.Amount, paid.Amount-1)
+
+	_, err = ReturnRefund(pricing, []CartItem{{SKU: "IP12-64-BLK", Quantity: 3}})
+	assert.Error(t, err)
+	_, err = ReturnRefund(pricing, []CartItem{{SKU: "IPSE-64-BLK", Quantity: 1}})
+	assert.Error(t, err)
+}
+
+func TestReturnRefundAmountOff(t *testing.T) {
+	cart := withVariants(CartState{
+		Currency:   "USD",
+		Items:      []CartItem{{SKU: "IP12PRO-128-GRA", Quantity: 2}},
+		CouponCode: "TAKE50",
+	})
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	require.NoError(t, err)
+	require.Equal(t, Money{Amount: 208923, Currency: "USD"}, pricing.Total)
+
+	// Shipping is free, so returning everything gives back all that was
+	// captured
+	refund, err := ReturnRefund(pricing, cart.Items)
+	require.NoError(t, err)
+	assert.Equal(t, pricing.Total, refund)
+
+	// Returning the units one at a time never gives back more
+	one, err := ReturnRefund(pricing, []CartItem{{SKU: "IP12PRO-128-GRA", Quantity: 1}})
+	require.NoError(t, err)
+	assert.Equal(t, Money{Amount: 104461, Currency: "USD"}, one)
+	assert.LessOrEqual(t, 2*one.Amount, pricing.Total.Amount)
+
+	// Orders priced before the discount was spread over the lines have it
+	// on the order alone, and are refunded the same
+	legacy := pricing
+	legacy.Lines = []PricedLine{pricing.Lines[0]}
+	legacy.Lines[0].Discount = Money{Currency: "USD"}
+	legacy.Lines[0].Total = legacy.Lines[0].Subtotal
+	refund, err = ReturnRefund(legacy, cart.Items)
+	require.NoError(t, err)
+	assert.Equal(t, pricing.Total, refund)
+	one, err = ReturnRefund(legacy, []CartItem{{SKU: "IP12PRO-128-GRA", Quantity: 1}})
+	require.NoError(t, err)
+	assert.LessOrEqual(t, 2*one.Amount, pricing.Total.Amount)
+}
+
+func TestValidateReturn(t *testing.T) {
+	deliveredAt := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	order := Order{
+		Status:   OrderDelivered,
+		Items:    []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}},
+		Shipment: Shipment{DeliveredAt: deliveredAt},
+		Returns: []Return{
+			{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}, Status: ReturnLabelIssued},
+			{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}, Status: ReturnExpired},
+		},
+	}
+	now := deliveredAt.Add(24 * time.Hour)
+	one := ReturnRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}}
+
+	assert.NoError(t, order.validateReturn(one, now))
+
+	for _, request := range []ReturnRequest{
+		{},
+		{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 0}}},
+		{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}},
+		{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}, {SKU: "IP12-64-BLK", Quantity: 1}}},
+		{Items: []CartItem{{SKU: "IP11-64-BLK", Quantity: 1}}},
+	} {
+		err := order.validateReturn(request, now)
+		assert.Equal(t, ErrorTypeInvalidRequest, applicationErrorType(err), "%+v", request)
+	}
+
+	err := order.validateReturn(one, deliveredAt.Add(returnWindow+time.Second))
+	assert.Equal(t, ErrorTypeOrderStatus, applicationErrorType(err))
+
+	order.Status = OrderShipped
+	err = order.validateReturn(one, now)
+	assert.Equal(t, ErrorTypeOrderStatus, applicationErrorType(err))
+}
@@ -1,44 +1,176 @@
 package app
 
 type (
+	// Product is a catalog entry, sold in one or more variants.
 	Product struct {
 		Id          int
 		Name        string
 		Description string
 		Image       string
-		Price       float32
+		// Prices are one price per currency, for the variants that have no
+		// prices of their own.
+		Prices []Money
+		// Variants are the configurations the product comes in, such as
+		// "256GB, Graphite". Every product has at least one.
+		Variants []Variant
+	}
+
+	// Variant is one configuration of a product, with its own stock keeping
+	// unit (SKU). Carts hold SKUs and the inventory counts them.
+	Variant struct {
+		SKU string
+		// Options tell the variants of a product apart, e.g. Storage:
+		// 256GB and Colour: Graphite. All of them have the same option
+		// names, in the same order.
+		Options []VariantOption
+		// Prices replace the product's, if set.
+		Prices []Money `json:",omitempty"`
+		// Image replaces the product's, if set.
+		Image string `json:",omitempty"`
+		// Stock is how many units of the variant are on hand.
+		Stock int
+	}
+
+	VariantOption struct {
+		Name  string
+		Value string
+	}
+
+	// ProductVariant is a variant together with the product it belongs to,
+	// with the product's image and prices filled in where the variant has
+	// none. It is what a cart keeps of each SKU in it.
+	ProductVariant struct {
+		SKU       string
+		ProductId int
+		// Name is the product's name followed by the option values, e.g.
+		// "iPhone 12 Pro 256GB Graphite".
+		Name    string
+		Options []VariantOption
+		Image   string
+		Prices  []Money
+	}
+
+	// PricedProduct is a product as shown to a shopper paying in one currency.
+	PricedProduct struct {
+		Id          int
+		Name        string
+		Description string
+		Image       string
+		// Price is the lowest price of the variants, to show as "from".
+		Price    Money
+		Variants []PricedVariant
+	}
+
+	// PricedVariant is a variant as shown to a shopper paying in one
+	// currency.
+	PricedVariant struct {
+		SKU     string
+		Name    string
+		Options []VariantOption
+		Image   string
+		Price   Money
 	}
 )
 
-var Products = []Product{
-	{
-		Id:          0,
-		Name:        "iPhone 12 Pro",
-		Description: "Test",
-		Image:       "https://images.unsplash.com/photo-1603921326210-6edd2d60ca68",
-		Price:       999,
-	},
-	{
-		Id:          1,
-		Name:        "iPhone 12",
-		Description: "Test",
-		Image:       "https://images.unsplash.com/photo-1611472173362-3f53dbd65d80",
-		Price:       699,
-	},
-	{
-		Id:          2,
-		Name:        "iPhone SE",
-		Description: "399",
-		Image:       "https://images.unsplash.com/photo-1529618160092-2f8ccc8e087b",
-		Price:       399,
-	},
-	{
-		Id:          3,
-		Name:        "iPhone 11",
-		Description: "599",
-		Image:       "https://images.unsplash.com/photo-1574755393849-623942496936",
-		Price:       599,
-	},
+// DefaultCurrency is used for carts that were created without a currency.
+const DefaultCurrency = "USD"
+
+var SupportedCurrencies = []string{"USD", "EUR", "GBP", "JPY"}
+
+// Variant looks up one of the product's variants by SKU.
+func (p Product) Variant(sku string) (ProductVariant, bool) {
+	for _, variant := range p.Variants {
+		if variant.SKU == sku {
+			return p.productVariant(variant), true
+		}
+	}
+	return ProductVariant{}, false
+}
+
+func (p Product) productVariant(variant Variant) ProductVariant {
+	name := p.Name
+	for _, option := range variant.Options {
+		name += " " + option.Value
+	}
+	image := variant.Image
+	if image == "" {
+		image = p.Image
+	}
+	prices := variant.Prices
+	if len(prices) == 0 {
+		prices = p.Prices
+	}
+	return ProductVariant{
+		SKU:       variant.SKU,
+		ProductId: p.Id,
+		Name:      name,
+		Options:   variant.Options,
+		Image:     image,
+		Prices:    prices,
+	}
+}
+
+// Price returns the variant's price in the given currency, if it is sold in
+// that currency.
+func (v ProductVariant) Price(currency string) (Money, bool) {
+	return priceIn(v.Prices, currency)
+}
+
+func priceIn(prices []Money, currency string) (Money, bool) {
+	for _, price := range prices {
+		if price.Currency == currency {
+			return price, true
+		}
+	}
+	return Money{}, false
+}
+
+// ProductsIn lists the products that can be bought in the given currency,
+// with those of their variants that can.
+func ProductsIn(products []Product, currency string) []PricedProduct {
+	priced := make([]PricedProduct, 0, len(products))
+	for _, product := range products {
+		variants := make([]PricedVariant, 0, len(product.Variants))
+		var from Money
+		for _, variant := range product.Variants {
+			v := product.productVariant(variant)
+			price, ok := v.Price(currency)
+			if !ok {
+				continue
+			}
+			if len(variants) == 0 || price.Amount < from.Amount {
+				from = price
+			}
+			variants = append(variants, PricedVariant{
+				SKU:     v.SKU,
+				Name:    v.Name,
+				Options: v.Options,
+				Image:   v.Image,
+				Price:   price,
+			})
+		}
+		if len(variants) == 0 {
+			continue
+		}
+		priced = append(priced, PricedProduct{
+			Id:          product.Id,
+			Name:        product.Name,
+			Description: product.Description,
+			Image:       product.Image,
+			Price:       from,
+			Variants:    variants,
+		})
+	}
+	return priced
+}
+
+func IsSupportedCurrency(currency string) bool {
+	for _, c := range SupportedCurrencies {
+		if c == currency {
+			return true
+		}
+	}
+	return false
 }
 
 var SignalChannels = struct {
@@ -46,11 +178,23 @@ var SignalChannels = struct {
 	REMOVE_FROM_CART_CHANNEL string
 	UPDATE_EMAIL_CHANNEL     string
 	CHECKOUT_CHANNEL         string
+	FULFILLMENT_CHANNEL      string
+	DELIVERY_CHANNEL         string
+	CANCEL_ORDER_CHANNEL     string
+	RETURN_APPROVAL_CHANNEL  string
+	RETURN_RECEIPT_CHANNEL   string
+	APPLY_COUPON_CHANNEL     string
 }{
 	ADD_TO_CART_CHANNEL:      "ADD_TO_CART_CHANNEL",
 	REMOVE_FROM_CART_CHANNEL: "REMOVE_FROM_CART_CHANNEL",
 	UPDATE_EMAIL_CHANNEL:     "UPDATE_EMAIL_CHANNEL",
 	CHECKOUT_CHANNEL:         "CHECKOUT_CHANNEL",
+	FULFILLMENT_CHANNEL:      "FULFILLMENT_CHANNEL",
+	DELIVERY_CHANNEL:         "DELIVERY_CHANNEL",
+	CANCEL_ORDER_CHANNEL:     "CANCEL_ORDER_CHANNEL",
+	RETURN_APPROVAL_CHANNEL:  "RETURN_APPROVAL_CHANNEL",
+	RETURN_RECEIPT_CHANNEL:   "RETURN_RECEIPT_CHANNEL",
+	APPLY_COUPON_CHANNEL:     "APPLY_COUPON_CHANNEL",
 }
 
 var RouteTypes = struct {
@@ -58,11 +202,23 @@ var RouteTypes = struct {
 	REMOVE_FROM_CART string
 	UPDATE_EMAIL     string
 	CHECKOUT         string
+	FULFILLMENT      string
+	DELIVERY         string
+	CANCEL_ORDER     string
+	RETURN_APPROVAL  string
+	RETURN_RECEIPT   string
+	APPLY_COUPON     string
 }{
 	ADD_TO_CART:      "add_to_cart",
 	REMOVE_FROM_CART: "remove_from_cart",
 	UPDATE_EMAIL:     "update_email",
 	CHECKOUT:         "checkout",
+	FULFILLMENT:      "fulfillment",
+	DELIVERY:         "delivery",
+	CANCEL_ORDER:     "cancel_order",
+	RETURN_APPROVAL:  "return_approval",
+	RETURN_RECEIPT:   "return_receipt",
+	APPLY_COUPON:     "apply_coupon",
 }
 
 type RouteSignal struct {
@@ -72,6 +228,9 @@ type RouteSignal struct {
 type AddToCartSignal struct {
 	Route string
 	Item  CartItem
+	// Currency is the currency the shopper saw the price in. If set, it must
+	// match the cart's currency.
+	Currency string
 }
 
 type RemoveFromCartSignal struct {
@@ -85,6 +244,47 @@ type UpdateEmailSignal struct {
 }
 
 type CheckoutSignal struct {
+	Route           string
+	Email           string
+	ShippingAddress ShippingAddress
+}
+
+type FulfillmentSignal struct {
+	Route     string
+	Confirmed bool
+	// Carrier and TrackingNumber describe the shipment, if known.
+	Carrier        string
+	TrackingNumber string
+}
+
+// DeliverySignal tells an order its shipment has arrived.
+type DeliverySignal struct {
 	Route string
-	Email string
+}
+
+// CancelOrderSignal asks an order that has not shipped yet to be cancelled.
+type CancelOrderSignal struct {
+	Route  string
+	Reason string
+}
+
+// ReturnApprovalSignal approves or rejects a requested return. Reason is
+// passed on to the customer.
+type ReturnApprovalSignal struct {
+	Route    string
+	Approved bool
+	Reason   string
+}
+
+// ReturnReceiptSignal reports that a return arrived at the warehouse and
+// which of its items passed inspection.
+type ReturnReceiptSignal struct {
+	Route    string
+	Accepted []CartItem
+}
+
+// ApplyCouponSignal sets the cart's coupon code. An empty code removes it.
+type ApplyCouponSignal struct {
+	Route string
+	Code  string
 }
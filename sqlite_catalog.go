@@ -0,0 +1,743 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteMigration is one step of bringing a catalog database up to date.
+type sqliteMigration func(tx *sql.Tx) error
+
+// sqlMigration is a step that only runs SQL.
+func sqlMigration(query string) sqliteMigration {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// sqliteCatalogMigrations bring a catalog database up to date. The database
+// remembers how many of them it has run in its user_version.
+var sqliteCatalogMigrations = []sqliteMigration{
+	sqlMigration(`
+	CREATE TABLE IF NOT EXISTS products (
+		id          INTEGER PRIMARY KEY,
+		name        TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		image       TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE IF NOT EXISTS prices (
+		product_id INTEGER NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+		currency   TEXT NOT NULL,
+		amount     INTEGER NOT NULL,
+		PRIMARY KEY (product_id, currency)
+	);
+	`),
+	sqlMigration(`
+	ALTER TABLE products ADD COLUMN updated_at TEXT NOT NULL DEFAULT '';
+	ALTER TABLE products ADD COLUMN deleted_at TEXT;
+	CREATE TABLE audit_log (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		at         TEXT NOT NULL,
+		admin      TEXT NOT NULL,
+		action     TEXT NOT NULL,
+		product_id INTEGER NOT NULL,
+		before     TEXT,
+		after      TEXT
+	);
+	CREATE INDEX audit_log_product_id ON audit_log (product_id);
+	`),
+	// Products from before variants get one, with no options, that goes by
+	// the product's ID until the next step names it.
+	sqlMigration(`
+	CREATE TABLE variants (
+		sku        TEXT PRIMARY KEY,
+		product_id INTEGER NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+		position   INTEGER NOT NULL,
+		image      TEXT NOT NULL DEFAULT '',
+		options    TEXT NOT NULL DEFAULT '[]'
+	);
+	CREATE INDEX variants_product_id ON variants (product_id);
+	CREATE TABLE variant_prices (
+		sku      TEXT NOT NULL REFERENCES variants (sku) ON DELETE CASCADE,
+		currency TEXT NOT NULL,
+		amount   INTEGER NOT NULL,
+		PRIMARY KEY (sku, currency)
+	);
+	INSERT INTO variants (sku, product_id, position) SELECT 'PRODUCT-' || id, id, 0 FROM products;
+	`),
+	sqlMigration(`ALTER TABLE variants ADD COLUMN stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0);`),
+	migrateLegacyVariants,
+	// Holds and restocks outlive the variants they are for, which are
+	// rewritten whenever their product is edited.
+	sqlMigration(`
+	CREATE TABLE holds (
+		cart_id  TEXT NOT NULL,
+		sku      TEXT NOT NULL,
+		quantity INTEGER NOT NULL CHECK (quantity > 0),
+		PRIMARY KEY (cart_id, sku)
+	);
+	CREATE INDEX holds_sku ON holds (sku);
+	CREATE TABLE restocks (
+		return_id TEXT NOT NULL,
+		sku       TEXT NOT NULL,
+		PRIMARY KEY (return_id, sku)
+	);
+	`),
+	// Variants dropped from a product are kept, so that carts holding them
+	// can still check out and their SKUs stay taken.
+	sqlMigration(`ALTER TABLE variants ADD COLUMN removed_at TEXT;`),
+}
+
+// migrateLegacyVariants gives the variants made up for products from before
+// variants a SKU derived from the product's name, such as IPHONE-12. They
+// start out of stock; the database never knew how many were on hand.
+func migrateLegacyVariants(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT v.sku, p.id, p.name FROM variants v JOIN products p ON p.id = v.product_id
+		WHERE v.sku = 'PRODUCT-' || p.id AND v.options = '[]' ORDER BY p.id`)
+	if err != nil {
+		return err
+	}
+	type legacy struct {
+		sku  string
+		id   int
+		name string
+	}
+	var variants []legacy
+	for rows.Next() {
+		var v legacy
+		if err := rows.Scan(&v.sku, &v.id, &v.name); err != nil {
+			rows.Close()
+			return err
+		}
+		variants = append(variants, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, v := range variants {
+		sku := skuFromName(v.name, v.id)
+		var taken int
+		if err := tx.QueryRow(`SELECT count(*) FROM variants WHERE sku = ?`, sku).Scan(&taken); err != nil {
+			return err
+		}
+		if taken > 0 {
+			sku = skuFromName(v.name+" "+strconv.Itoa(v.id), v.id)
+		}
+		if _, err := tx.Exec(`UPDATE variants SET sku = ? WHERE sku = ?`, sku, v.sku); err != nil {
+			return fmt.Errorf("product %d: %w", v.id, err)
+		}
+	}
+	return nil
+}
+
+// skuFromName turns a product name into a SKU, e.g. "iPhone 12 (64GB)"
+// into IPHONE-12-64GB. Names with nothing to make one from fall back to
+// ITEM and the product ID.
+func skuFromName(name string, id int) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	sku := b.String()
+	if len(sku) > 64 {
+		sku = strings.TrimRight(sku[:64], "-")
+	}
+	if sku == "" {
+		sku = "ITEM-" + strconv.Itoa(id)
+	}
+	return sku
+}
+
+// SQLiteCatalog is a CatalogEditor stored in a SQLite database, so the
+// product list can change while the API and worker are running. Prices are
+// stored in minor units, like Money, and variant options as JSON.
+type SQLiteCatalog struct {
+	db *sql.DB
+}
+
+// OpenSQLiteCatalog opens the catalog database at path, creating it and its
+// tables if needed.
+func OpenSQLiteCatalog(path string) (*SQLiteCatalog, error) {
+	// Writes take the lock up front, so that the API and the worker can
+	// open the same database at once.
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if err := migrateSQLiteCatalog(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating catalog database: %w", err)
+	}
+	return &SQLiteCatalog{db: db}, nil
+}
+
+func migrateSQLiteCatalog(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(sqliteCatalogMigrations); version++ {
+		if err := sqliteCatalogMigrations[version](tx); err != nil {
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteCatalog) Close() error {
+	return s.db.Close()
+}
+
+// querier is what reading a product needs, from the database or from a
+// transaction.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s *SQLiteCatalog) Products(ctx context.Context) ([]Product, error) {
+	records, err := s.records(ctx, `WHERE deleted_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	products := make([]Product, 0, len(records))
+	for _, record := range records {
+		products = append(products, record.Product)
+	}
+	return products, nil
+}
+
+// Product does not find deleted products, so they cannot be added to a
+// cart any more.
+func (s *SQLiteCatalog) Product(ctx context.Context, id int) (Product, bool, error) {
+	record, ok, err := s.record(ctx, s.db, id)
+	if err != nil || !ok || record.DeletedAt != nil {
+		return Product{}, false, err
+	}
+	return record.Product, true, nil
+}
+
+// Variant does not find the variants of deleted products either.
+func (s *SQLiteCatalog) Variant(ctx context.Context, sku string) (ProductVariant, bool, error) {
+	var productID int
+	err := s.db.QueryRowContext(ctx, `SELECT product_id FROM variants WHERE sku = ?`, sku).Scan(&productID)
+	if err == sql.ErrNoRows {
+		return ProductVariant{}, false, nil
+	}
+	if err != nil {
+		return ProductVariant{}, false, err
+	}
+
+	product, ok, err := s.Product(ctx, productID)
+	if err != nil || !ok {
+		return ProductVariant{}, false, err
+	}
+	variant, ok := product.Variant(sku)
+	return variant, ok, nil
+}
+
+func (s *SQLiteCatalog) Records(ctx context.Context) ([]ProductRecord, error) {
+	return s.records(ctx, ``)
+}
+
+func (s *SQLiteCatalog) records(ctx context.Context, where string) ([]ProductRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, description, image, updated_at, deleted_at FROM products `+where+` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ProductRecord
+	for rows.Next() {
+		record, err := scanProductRecord(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range records {
+		if err := s.details(ctx, s.db, &records[i].Product); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// record looks up a product whether or not it was deleted.
+func (s *SQLiteCatalog) record(ctx context.Context, q querier, id int) (ProductRecord, bool, error) {
+	row := q.QueryRowContext(ctx, `SELECT id, name, description, image, updated_at, deleted_at FROM products WHERE id = ?`, id)
+	record, err := scanProductRecord(row.Scan)
+	if err == sql.ErrNoRows {
+		return ProductRecord{}, false, nil
+	}
+	if err != nil {
+		return ProductRecord{}, false, err
+	}
+
+	if err := s.details(ctx, q, &record.Product); err != nil {
+		return ProductRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+func scanProductRecord(scan func(dest ...interface{}) error) (ProductRecord, error) {
+	var record ProductRecord
+	var updatedAt string
+	var deletedAt sql.NullString
+	err := scan(&record.Id, &record.Name, &record.Description, &record.Image, &updatedAt, &deletedAt)
+	if err != nil {
+		return ProductRecord{}, err
+	}
+
+	// Products from before the audit log have no update time.
+	if updatedAt != "" {
+		if record.UpdatedAt, err = time.Parse(time.RFC3339Nano, updatedAt); err != nil {
+			return ProductRecord{}, err
+		}
+	}
+	if deletedAt.Valid {
+		at, err := time.Parse(time.RFC3339Nano, deletedAt.String)
+		if err != nil {
+			return ProductRecord{}, err
+		}
+		record.DeletedAt = &at
+	}
+	return record, nil
+}
+
+// details reads a product's prices and variants.
+func (s *SQLiteCatalog) details(ctx context.Context, q querier, product *Product) error {
+	var err error
+	if product.Prices, err = s.prices(ctx, q, `SELECT amount, currency FROM prices WHERE product_id = ? ORDER BY rowid`, product.Id); err != nil {
+		return err
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT sku, image, options, stock FROM variants WHERE product_id = ? AND removed_at IS NULL ORDER BY position`, product.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	product.Variants = make([]Variant, 0)
+	for rows.Next() {
+		var variant Variant
+		var options string
+		if err := rows.Scan(&variant.SKU, &variant.Image, &options, &variant.Stock); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(options), &variant.Options); err != nil {
+			return fmt.Errorf("SKU %s: %w", variant.SKU, err)
+		}
+		if len(variant.Options) == 0 {
+			variant.Options = nil
+		}
+		product.Variants = append(product.Variants, variant)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for i, variant := range product.Variants {
+		prices, err := s.prices(ctx, q, `SELECT amount, currency FROM variant_prices WHERE sku = ? ORDER BY rowid`, variant.SKU)
+		if err != nil {
+			return err
+		}
+		// Variants priced like their product have no prices of their own.
+		if len(prices) > 0 {
+			product.Variants[i].Prices = prices
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteCatalog) prices(ctx context.Context, q querier, query string, key interface{}) ([]Money, error) {
+	rows, err := q.QueryContext(ctx, query, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := make([]Money, 0)
+	for rows.Next() {
+		var price Money
+		if err := rows.Scan(&price.Amount, &price.Currency); err != nil {
+			return nil, err
+		}
+		prices = append(prices, price)
+	}
+	return prices, rows.Err()
+}
+
+func (s *SQLiteCatalog) Create(ctx context.Context, admin string, product Product) (ProductRecord, error) {
+	if err := ValidateProduct(product); err != nil {
+		return ProductRecord{}, err
+	}
+	return s.change(ctx, product.Id, func(tx *sql.Tx, existing ProductRecord, exists bool, now time.Time) (ProductRecord, error) {
+		if exists {
+			return ProductRecord{}, fmt.Errorf("%w: %d", ErrProductExists, product.Id)
+		}
+		if err := writeProduct(ctx, tx, product, skus(product), now); err != nil {
+			return ProductRecord{}, err
+		}
+		if err := writeAudit(ctx, tx, now, admin, AuditCreated, product.Id, nil, &product); err != nil {
+			return ProductRecord{}, err
+		}
+		return ProductRecord{Product: product, UpdatedAt: now}, nil
+	})
+}
+
+func (s *SQLiteCatalog) Update(ctx context.Context, admin string, product Product, stocked []string) (ProductRecord, error) {
+	if err := ValidateProduct(product); err != nil {
+		return ProductRecord{}, err
+	}
+	return s.change(ctx, product.Id, func(tx *sql.Tx, existing ProductRecord, exists bool, now time.Time) (ProductRecord, error) {
+		if !exists || existing.DeletedAt != nil {
+			return ProductRecord{}, fmt.Errorf("%w: %d", ErrProductNotFound, product.Id)
+		}
+		if err := writeProduct(ctx, tx, product, stocked, now); err != nil {
+			return ProductRecord{}, err
+		}
+		// Read back, for the stock the variants kept.
+		updated, _, err := s.record(ctx, tx, product.Id)
+		if err != nil {
+			return ProductRecord{}, err
+		}
+		if err := writeAudit(ctx, tx, now, admin, AuditUpdated, product.Id, &existing.Product, &updated.Product); err != nil {
+			return ProductRecord{}, err
+		}
+		return updated, nil
+	})
+}
+
+func (s *SQLiteCatalog) Delete(ctx context.Context, admin string, id int) (ProductRecord, error) {
+	return s.change(ctx, id, func(tx *sql.Tx, existing ProductRecord, exists bool, now time.Time) (ProductRecord, error) {
+		if !exists || existing.DeletedAt != nil {
+			return ProductRecord{}, fmt.Errorf("%w: %d", ErrProductNotFound, id)
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE products SET updated_at = ?, deleted_at = ? WHERE id = ?`,
+			formatCatalogTime(now), formatCatalogTime(now), id)
+		if err != nil {
+			return ProductRecord{}, err
+		}
+		if err := writeAudit(ctx, tx, now, admin, AuditDeleted, id, &existing.Product, nil); err != nil {
+			return ProductRecord{}, err
+		}
+		existing.UpdatedAt = now
+		existing.DeletedAt = &now
+		return existing, nil
+	})
+}
+
+func (s *SQLiteCatalog) SetStock(ctx context.Context, admin string, sku string, stock int) (ProductRecord, error) {
+	if stock < 0 {
+		return ProductRecord{}, fmt.Errorf("%w: stock %d", ErrInvalidQuantity, stock)
+	}
+	var id int
+	err := s.db.QueryRowContext(ctx, `SELECT product_id FROM variants WHERE sku = ?`, sku).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ProductRecord{}, fmt.Errorf("%w: %s", ErrVariantNotFound, sku)
+	}
+	if err != nil {
+		return ProductRecord{}, err
+	}
+
+	return s.change(ctx, id, func(tx *sql.Tx, existing ProductRecord, exists bool, now time.Time) (ProductRecord, error) {
+		after := existing.Product
+		after.Variants = append([]Variant(nil), existing.Variants...)
+		i := -1
+		for j, variant := range after.Variants {
+			if variant.SKU == sku {
+				i = j
+			}
+		}
+		if !exists || existing.DeletedAt != nil || i < 0 {
+			return ProductRecord{}, fmt.Errorf("%w: %s", ErrVariantNotFound, sku)
+		}
+		after.Variants[i].Stock = stock
+
+		if _, err := tx.ExecContext(ctx, `UPDATE variants SET stock = ? WHERE sku = ?`, stock, sku); err != nil {
+			return ProductRecord{}, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE products SET updated_at = ? WHERE id = ?`, formatCatalogTime(now), id); err != nil {
+			return ProductRecord{}, err
+		}
+		if err := writeAudit(ctx, tx, now, admin, AuditStocked, id, &existing.Product, &after); err != nil {
+			return ProductRecord{}, err
+		}
+		return ProductRecord{Product: after, UpdatedAt: now}, nil
+	})
+}
+
+// change runs one edit of a product in a transaction, handing it the
+// product as it was.
+func (s *SQLiteCatalog) change(ctx context.Context, id int, edit func(tx *sql.Tx, existing ProductRecord, exists bool, now time.Time) (ProductRecord, error)) (ProductRecord, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ProductRecord{}, err
+	}
+	defer tx.Rollback()
+
+	existing, exists, err := s.record(ctx, tx, id)
+	if err != nil {
+		return ProductRecord{}, err
+	}
+	record, err := edit(tx, existing, exists, time.Now().UTC())
+	if err != nil {
+		return ProductRecord{}, err
+	}
+	return record, tx.Commit()
+}
+
+// Import adds the products the catalog has never had, such as those from a
+// catalog file. Products it already has, or had and deleted, are left as
+// they are, so importing the same file again on every start is harmless.
+// Either all of the new products are saved or none are.
+func (s *SQLiteCatalog) Import(ctx context.Context, admin string, products []Product) error {
+	if err := validateCatalog(products); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, product := range products {
+		product := product
+		_, exists, err := s.record(ctx, tx, product.Id)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := writeProduct(ctx, tx, product, skus(product), now); err != nil {
+			return err
+		}
+		if err := writeAudit(ctx, tx, now, admin, AuditImported, product.Id, nil, &product); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// writeProduct saves a product and its variants. Only the variants listed
+// in stocked have their stock set; the others keep what they have, or none
+// if they are new. Variants the product no longer has are marked removed
+// rather than deleted.
+func writeProduct(ctx context.Context, tx *sql.Tx, product Product, stocked []string, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO products (id, name, description, image, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, description = excluded.description,
+			image = excluded.image, updated_at = excluded.updated_at`,
+		product.Id, product.Name, product.Description, product.Image, formatCatalogTime(now))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM prices WHERE product_id = ?`, product.Id); err != nil {
+		return err
+	}
+	for _, price := range product.Prices {
+		_, err := tx.ExecContext(ctx, `INSERT INTO prices (product_id, currency, amount) VALUES (?, ?, ?)`,
+			product.Id, price.Currency, price.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A SKU can only be used by one product. Deleted products and removed
+	// variants keep theirs, so their SKUs are not handed to something else.
+	for _, variant := range product.Variants {
+		var owner int
+		err := tx.QueryRowContext(ctx, `SELECT product_id FROM variants WHERE sku = ? AND product_id != ?`, variant.SKU, product.Id).Scan(&owner)
+		if err == nil {
+			return fmt.Errorf("%w: %s is a variant of product %d", ErrSKUTaken, variant.SKU, owner)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	setStock := make(map[string]bool, len(stocked))
+	for _, sku := range stocked {
+		setStock[sku] = true
+	}
+	kept := make(map[string]bool, len(product.Variants))
+	for position, variant := range product.Variants {
+		kept[variant.SKU] = true
+		options, err := json.Marshal(variant.Options)
+		if err != nil {
+			return err
+		}
+		stock := 0
+		if setStock[variant.SKU] {
+			stock = variant.Stock
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO variants (sku, product_id, position, image, options, stock) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (sku) DO UPDATE SET position = excluded.position, image = excluded.image,
+				options = excluded.options, removed_at = NULL,
+				stock = CASE WHEN ? THEN excluded.stock ELSE variants.stock END`,
+			variant.SKU, product.Id, position, variant.Image, string(options), stock, setStock[variant.SKU])
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM variant_prices WHERE sku = ?`, variant.SKU); err != nil {
+			return err
+		}
+		for _, price := range variant.Prices {
+			_, err := tx.ExecContext(ctx, `INSERT INTO variant_prices (sku, currency, amount) VALUES (?, ?, ?)`,
+				variant.SKU, price.Currency, price.Amount)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT sku FROM variants WHERE product_id = ? AND removed_at IS NULL`, product.Id)
+	if err != nil {
+		return err
+	}
+	var removed []string
+	for rows.Next() {
+		var sku string
+		if err := rows.Scan(&sku); err != nil {
+			rows.Close()
+			return err
+		}
+		if !kept[sku] {
+			removed = append(removed, sku)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, sku := range removed {
+		if _, err := tx.ExecContext(ctx, `UPDATE variants SET removed_at = ? WHERE sku = ?`, formatCatalogTime(now), sku); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skus lists the SKUs of a product's variants.
+func skus(product Product) []string {
+	skus := make([]string, 0, len(product.Variants))
+	for _, variant := range product.Variants {
+		skus = append(skus, variant.SKU)
+	}
+	return skus
+}
+
+func writeAudit(ctx context.Context, tx *sql.Tx, now time.Time, admin string, action string, productID int, before *Product, after *Product) error {
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO audit_log (at, admin, action, product_id, before, after) VALUES (?, ?, ?, ?, ?, ?)`,
+		formatCatalogTime(now), admin, action, productID, beforeJSON, afterJSON)
+	return err
+}
+
+func auditJSON(product *Product) (sql.NullString, error) {
+	if product == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(product)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func (s *SQLiteCatalog) AuditLog(ctx context.Context, productID *int) ([]AuditEntry, error) {
+	query := `SELECT id, at, admin, action, product_id, before, after FROM audit_log`
+	var args []interface{}
+	if productID != nil {
+		query += ` WHERE product_id = ?`
+		args = append(args, *productID)
+	}
+	rows, err := s.db.QueryContext(ctx, query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var at string
+		var before, after sql.NullString
+		if err := rows.Scan(&entry.Id, &at, &entry.Admin, &entry.Action, &entry.ProductId, &before, &after); err != nil {
+			return nil, err
+		}
+		if entry.At, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return nil, err
+		}
+		if entry.Before, err = parseAuditJSON(before); err != nil {
+			return nil, err
+		}
+		if entry.After, err = parseAuditJSON(after); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func parseAuditJSON(data sql.NullString) (*Product, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	var product Product
+	if err := json.Unmarshal([]byte(data.String), &product); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func formatCatalogTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SQLiteInventory is an InventoryStore kept in a catalog database. The
+// units on hand are the stock of the catalog's variants, so stocking a
+// product in the catalog puts it on sale, and every worker using the
+// database shares the same holds.
+type SQLiteInventory struct {
+	db *sql.DB
+}
+
+// Inventory is the inventory of the variants in the catalog.
+func (s *SQLiteCatalog) Inventory() *SQLiteInventory {
+	return &SQLiteInventory{db: s.db}
+}
+
+func (s *SQLiteInventory) Reserve(ctx context.Context, cartID string, sku string, quantity int) error {
+	if quantity < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidQuantity, quantity)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stock int
+	err = tx.QueryRowContext(ctx, `SELECT stock FROM variants WHERE sku = ?`, sku).Scan(&stock)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: SKU %s is not stocked", ErrOutOfStock, sku)
+	}
+	if err != nil {
+		return err
+	}
+	var heldByOthers int
+	err = tx.QueryRowContext(ctx, `SELECT coalesce(sum(quantity), 0) FROM holds WHERE sku = ? AND cart_id != ?`, sku, cartID).Scan(&heldByOthers)
+	if err != nil {
+		return err
+	}
+	if available := stock - heldByOthers; quantity > available {
+		return fmt.Errorf("%w: %d of SKU %s requested, %d available", ErrOutOfStock, quantity, sku, available)
+	}
+
+	if quantity == 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM holds WHERE cart_id = ? AND sku = ?`, cartID, sku)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO holds (cart_id, sku, quantity) VALUES (?, ?, ?)
+			ON CONFLICT (cart_id, sku) DO UPDATE SET quantity = excluded.quantity`,
+			cartID, sku, quantity)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteInventory) Release(ctx context.Context, cartID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM holds WHERE cart_id = ?`, cartID)
+	return err
+}
+
+// Commit never takes stock below zero, which it could otherwise do when an
+// admin lowers the stock of a variant that carts already hold.
+func (s *SQLiteInventory) Commit(ctx context.Context, cartID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE variants SET stock = max(stock - (SELECT quantity FROM holds WHERE cart_id = ? AND sku = variants.sku), 0)
+		WHERE sku IN (SELECT sku FROM holds WHERE cart_id = ?)`,
+		cartID, cartID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM holds WHERE cart_id = ?`, cartID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteInventory) Available(ctx context.Context, sku string) (int, error) {
+	var available int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT coalesce((SELECT stock FROM variants WHERE sku = ?), 0) -
+			(SELECT coalesce(sum(quantity), 0) FROM holds WHERE sku = ?)`,
+		sku, sku).Scan(&available)
+	return available, err
+}
+
+func (s *SQLiteInventory) Restock(ctx context.Context, returnID string, sku string, quantity int) error {
+	if quantity <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidQuantity, quantity)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO restocks (return_id, sku) VALUES (?, ?)`, returnID, sku)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE variants SET stock = stock + ? WHERE sku = ?`, quantity, sku); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -27,13 +27,20 @@ func main() {
 	}
 
 	state := app.CartState{Items: make([]app.CartItem, 0)}
-	we, err := c.ExecuteWorkflow(context.Background(), options, app.CartWorkflow, state)
+	config, err := app.CartConfigFromEnv(app.DefaultCartConfig())
+	if err != nil {
+		log.Fatalln("invalid cart configuration", err)
+	}
+	we, err := c.ExecuteWorkflow(context.Background(), options, app.CartWorkflow, state, config)
 	if err != nil {
 		log.Fatalln("unable to execute workflow", err)
 	}
 
-	update := app.AddToCartSignal{Route: app.RouteTypes.ADD_TO_CART, Item: app.CartItem{ProductId:0, Quantity: 1}}
-	err = c.SignalWorkflow(context.Background(), workflowID, "", "ADD_TO_CART_CHANNEL", update)
+	update := app.AddToCartSignal{Route: app.RouteTypes.ADD_TO_CART, Item: app.CartItem{SKU: "IP12PRO-128-GRA", Quantity: 1}}
+	err = c.SignalWorkflow(context.Background(), we.GetID(), we.GetRunID(), "ADD_TO_CART_CHANNEL", update)
+	if err != nil {
+		log.Fatalln("Unable to signal workflow", err)
+	}
 
 	resp, err := c.QueryWorkflow(context.Background(), workflowID, "", "getCart")
 	if err != nil {
@@ -44,7 +51,8 @@ func main() {
 		log.Fatalln("Unable to decode query result", err)
 	}
 	// Prints a message similar to:
-	// 2021/03/31 15:43:54 Received query result Result map[Email: Items:[map[ProductId:0 Quantity:1]]]
+	// 2021/03/31 15:43:54 Received query result Result map[Email: Items:[map[Quantity:1 SKU:IP12PRO-128-GRA]]]
 	log.Println("Received query result", "Result", result)
 }
+
 // @@@SNIPEND
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/paymentintent"
+	"github.com/stripe/stripe-go/v72/refund"
+)
+
+// StripeProvider is a PaymentProvider backed by Stripe PaymentIntents with
+// manual capture, so Authorize only places a hold on the card.
+type StripeProvider struct {
+	Key string
+}
+
+func (p *StripeProvider) Authorize(_ context.Context, req PaymentRequest) (Payment, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:             stripe.Int64(req.Amount.Amount),
+		Currency:           stripe.String(strings.ToLower(req.Amount.Currency)),
+		Description:        stripe.String(req.Description),
+		ReceiptEmail:       stripe.String(req.Email),
+		PaymentMethod:      stripe.String("pm_card_visa"),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+		CaptureMethod:      stripe.String(string(stripe.PaymentIntentCaptureMethodManual)),
+		Confirm:            stripe.Bool(true),
+	}
+	params.SetIdempotencyKey(req.IdempotencyKey)
+
+	pi, err := p.intents().New(params)
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard {
+		return Payment{}, fmt.Errorf("%w: %s", ErrPaymentDeclined, stripeErr.Msg)
+	}
+	if err != nil {
+		return Payment{}, err
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		return Payment{}, fmt.Errorf("payment intent %s was not authorized: %s", pi.ID, pi.Status)
+	}
+
+	return paymentFromIntent(pi), nil
+}
+
+func (p *StripeProvider) Capture(_ context.Context, paymentID string, idempotencyKey string) (Payment, error) {
+	params := &stripe.PaymentIntentCaptureParams{}
+	params.SetIdempotencyKey(idempotencyKey)
+
+	pi, err := p.intents().Capture(paymentID, params)
+	if err != nil {
+		return Payment{}, err
+	}
+
+	return paymentFromIntent(pi), nil
+}
+
+func (p *StripeProvider) Void(_ context.Context, paymentID string, idempotencyKey string) (Payment, error) {
+	params := &stripe.PaymentIntentCancelParams{}
+	params.SetIdempotencyKey(idempotencyKey)
+
+	pi, err := p.intents().Cancel(paymentID, params)
+	if err != nil {
+		return Payment{}, err
+	}
+
+	return paymentFromIntent(pi), nil
+}
+
+func (p *StripeProvider) Refund(_ context.Context, paymentID string, amount Money, idempotencyKey string) (Payment, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentID),
+		Amount:        stripe.Int64(amount.Amount),
+	}
+	params.SetIdempotencyKey(idempotencyKey)
+
+	_, err := p.refunds().New(params)
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && (stripeErr.Code == stripe.ErrorCodeAmountTooLarge || stripeErr.Code == stripe.ErrorCodeChargeAlreadyRefunded) {
+		return Payment{}, fmt.Errorf("%w: %s", ErrRefundExceedsCapture, stripeErr.Msg)
+	}
+	if err != nil {
+		return Payment{}, err
+	}
+
+	pi, err := p.intents().Get(paymentID, nil)
+	if err != nil {
+		return Payment{}, err
+	}
+
+	return paymentFromIntent(pi), nil
+}
+
+func (p *StripeProvider) intents() paymentintent.Client {
+	return paymentintent.Client{B: stripe.GetBackend(stripe.APIBackend), Key: p.Key}
+}
+
+func (p *StripeProvider) refunds() refund.Client {
+	return refund.Client{B: stripe.GetBackend(stripe.APIBackend), Key: p.Key}
+}
+
+func paymentFromIntent(pi *stripe.PaymentIntent) Payment {
+	currency := strings.ToUpper(pi.Currency)
+	payment := Payment{
+		ID:       pi.ID,
+		Amount:   Money{Amount: pi.Amount, Currency: currency},
+		Status:   PaymentAuthorized,
+		Refunded: Money{Currency: currency},
+	}
+	if pi.Charges != nil {
+		for _, ch := range pi.Charges.Data {
+			payment.Refunded.Amount += ch.AmountRefunded
+		}
+	}
+
+	switch {
+	case pi.Status == stripe.PaymentIntentStatusCanceled:
+		payment.Status = PaymentVoided
+	case pi.Status == stripe.PaymentIntentStatusSucceeded && payment.Refunded.Amount > 0 && payment.Refunded.Amount >= pi.AmountReceived:
+		payment.Status = PaymentRefunded
+	case pi.Status == stripe.PaymentIntentStatusSucceeded:
+		payment.Status = PaymentCaptured
+	}
+
+	return payment
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// UpdateNames are the workflow updates a cart or order accepts. Unlike the
+// matching signals, an update tells the caller whether it worked.
+var UpdateNames = struct {
+	ADD_TO_CART      string
+	REMOVE_FROM_CART string
+	CHECKOUT         string
+	REQUEST_RETURN   string
+	REFUND_ORDER     string
+}{
+	ADD_TO_CART:      "ADD_TO_CART",
+	REMOVE_FROM_CART: "REMOVE_FROM_CART",
+	CHECKOUT:         "CHECKOUT",
+	REQUEST_RETURN:   "REQUEST_RETURN",
+	REFUND_ORDER:     "REFUND_ORDER",
+}
+
+// Types of the application errors returned by cart updates and activities.
+// Callers can switch on temporal.ApplicationError.Type() to tell them apart.
+const (
+	ErrorTypeInvalidRequest  = "InvalidRequest"
+	ErrorTypeCartClosed      = "CartClosed"
+	ErrorTypeCartExpired     = "CartExpired"
+	ErrorTypeNotCheckedOut   = "NotCheckedOut"
+	ErrorTypeOutOfStock      = "OutOfStock"
+	ErrorTypeInvalidQuantity = "InvalidQuantity"
+	ErrorTypePaymentDeclined = "PaymentDeclined"
+	ErrorTypeCouponInvalid   = "CouponInvalid"
+	ErrorTypeCheckoutFailed  = "CheckoutFailed"
+	// ErrorTypeOrderStatus means the order has moved past the point where
+	// the request applies, e.g. confirming fulfillment of a shipped order.
+	ErrorTypeOrderStatus = "OrderStatus"
+	// ErrorTypeReturnStatus is the same for a return.
+	ErrorTypeReturnStatus = "ReturnStatus"
+	// ErrorTypeRefundExceeded means a refund would give back more than was
+	// captured.
+	ErrorTypeRefundExceeded = "RefundExceeded"
+)
+
+// cartErrorTypes are the error types safe to pass on to callers as they are.
+// Anything else is an internal failure.
+var cartErrorTypes = map[string]bool{
+	ErrorTypeInvalidRequest:  true,
+	ErrorTypeCartClosed:      true,
+	ErrorTypeCartExpired:     true,
+	ErrorTypeNotCheckedOut:   true,
+	ErrorTypeOutOfStock:      true,
+	ErrorTypeInvalidQuantity: true,
+	ErrorTypePaymentDeclined: true,
+	ErrorTypeCouponInvalid:   true,
+	ErrorTypeCheckoutFailed:  true,
+	ErrorTypeOrderStatus:     true,
+	ErrorTypeReturnStatus:    true,
+	ErrorTypeRefundExceeded:  true,
+}
+
+func invalidRequest(format string, args ...interface{}) error {
+	return temporal.NewNonRetryableApplicationError(fmt.Sprintf(format, args...), ErrorTypeInvalidRequest, nil)
+}
+
+// updateError unwraps the typed error behind a failed activity, so the caller
+// of an update sees "OutOfStock" rather than the activity that raised it.
+func updateError(err error) error {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && cartErrorTypes[appErr.Type()] {
+		return appErr
+	}
+	return err
+}
+
+// errorType is the type a checkout failure is reported under.
+func errorType(err error) string {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && cartErrorTypes[appErr.Type()] {
+		return appErr.Type()
+	}
+	return ErrorTypeCheckoutFailed
+}
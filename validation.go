@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// MaxQuantity is the most units of one SKU a cart may hold.
+const MaxQuantity = 99
+
+// maxRejections bounds how many rejected commands a cart or order remembers.
+const maxRejections = 20
+
+// ErrCartExpired is returned for any change to a cart that has expired.
+var ErrCartExpired = temporal.NewNonRetryableApplicationError("cart has expired, start a new one", ErrorTypeCartExpired, nil)
+
+// Rejection is a signal a cart or order refused, and why. Signals cannot
+// answer their sender, so the workflow keeps the most recent rejections for
+// its query.
+type Rejection struct {
+	Command string
+	Type    string
+	Reason  string
+	At      time.Time
+}
+
+// ValidateEmail checks that email is a bare address such as
+// "val@temporal.io".
+func ValidateEmail(email string) error {
+	if email == "" {
+		return invalidRequest("email is required")
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return invalidRequest("invalid email address %q", email)
+	}
+	return nil
+}
+
+// MaxAddressLength is the longest any field of a ShippingAddress may be.
+const MaxAddressLength = 100
+
+// ValidateShippingAddress checks that every field but Line2 and Region is
+// filled in, none is too long, and Country is a two letter code.
+func ValidateShippingAddress(address ShippingAddress) error {
+	for _, field := range []struct {
+		name     string
+		value    string
+		optional bool
+	}{
+		{"name", address.Name, false},
+		{"first line", address.Line1, false},
+		{"second line", address.Line2, true},
+		{"city", address.City, false},
+		{"region", address.Region, true},
+		{"postal code", address.PostalCode, false},
+		{"country", address.Country, false},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			if field.optional {
+				continue
+			}
+			return invalidRequest("shipping address %s is required", field.name)
+		}
+		if len(field.value) > MaxAddressLength {
+			return invalidRequest("shipping address %s is longer than %d characters", field.name, MaxAddressLength)
+		}
+	}
+	if !countryCode.MatchString(address.Country) {
+		return invalidRequest("shipping address country %q is not a two letter code such as \"DE\"", address.Country)
+	}
+	return nil
+}
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// ValidateItem checks that an item names a SKU in the catalog and a
+// quantity between 1 and MaxQuantity.
+func ValidateItem(ctx context.Context, catalog Catalog, item CartItem) error {
+	_, ok, err := catalog.Variant(ctx, item.SKU)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return invalidRequest("unknown SKU %q", item.SKU)
+	}
+	return ValidateQuantity(item)
+}
+
+// ValidateQuantity is the part of ValidateItem a workflow can check by
+// itself. The cart looks variants up when they are added.
+func ValidateQuantity(item CartItem) error {
+	if item.Quantity <= 0 || item.Quantity > MaxQuantity {
+		return invalidRequest("quantity must be between 1 and %d, got %d", MaxQuantity, item.Quantity)
+	}
+	return nil
+}
+
+// reject records a refused command so the shopper can see why it had no
+// effect.
+func (state *CartState) reject(ctx workflow.Context, command string, err error) {
+	state.Rejections = appendRejection(ctx, state.Rejections, command, err)
+}
+
+// reject records a refused command on the order.
+func (order *Order) reject(ctx workflow.Context, command string, err error) {
+	order.Rejections = appendRejection(ctx, order.Rejections, command, err)
+}
+
+func appendRejection(ctx workflow.Context, rejections []Rejection, command string, err error) []Rejection {
+	workflow.GetLogger(ctx).Info("Rejected command", "Command", command, "Error", err)
+
+	rejection := Rejection{
+		Command: command,
+		Type:    ErrorTypeInvalidRequest,
+		Reason:  err.Error(),
+		At:      workflow.Now(ctx),
+	}
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && cartErrorTypes[appErr.Type()] {
+		rejection.Type = appErr.Type()
+		rejection.Reason = appErr.Error()
+	}
+
+	rejections = append(rejections, rejection)
+	if len(rejections) > maxRejections {
+		rejections = rejections[len(rejections)-maxRejections:]
+	}
+	return rejections
+}
+
+// The validators below run before an update is accepted and again once it
+// holds the cart, since the cart may have changed in between. They must not
+// change the cart.
+
+func (state *CartState) checkOpen() error {
+	if state.Status == CartExpired {
+		return ErrCartExpired
+	}
+	if state.Status != CartOpen {
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("cart is %s", state.Status), ErrorTypeCartClosed, nil)
+	}
+	return nil
+}
+
+func (state *CartState) validateAddToCart(message AddToCartSignal) error {
+	if err := state.checkOpen(); err != nil {
+		return err
+	}
+	if err := ValidateQuantity(message.Item); err != nil {
+		return err
+	}
+	if quantity := state.quantityOf(message.Item.SKU) + message.Item.Quantity; quantity > MaxQuantity {
+		return invalidRequest("at most %d of SKU %s fit in a cart", MaxQuantity, message.Item.SKU)
+	}
+	if err := state.checkCurrency(message); err != nil {
+		return invalidRequest("%s", err)
+	}
+	return nil
+}
+
+func (state *CartState) validateRemoveFromCart(message RemoveFromCartSignal) error {
+	if err := state.checkOpen(); err != nil {
+		return err
+	}
+	if err := ValidateQuantity(message.Item); err != nil {
+		return err
+	}
+	if state.quantityOf(message.Item.SKU) == 0 {
+		return invalidRequest("SKU %s is not in the cart", message.Item.SKU)
+	}
+	return nil
+}
+
+func (state *CartState) validateUpdateEmail(message UpdateEmailSignal) error {
+	if err := state.checkOpen(); err != nil {
+		return err
+	}
+	return ValidateEmail(message.Email)
+}
+
+func (state *CartState) validateApplyCoupon(message ApplyCouponSignal) error {
+	return state.checkOpen()
+}
+
+func (state *CartState) validateCheckout(message CheckoutSignal) error {
+	if err := state.checkOpen(); err != nil {
+		return err
+	}
+	if len(state.Items) == 0 {
+		return invalidRequest("cart is empty")
+	}
+	if err := ValidateEmail(message.Email); err != nil {
+		return err
+	}
+	return ValidateShippingAddress(message.ShippingAddress)
+}
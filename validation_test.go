@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateEmail(t *testing.T) {
+	assert.NoError(t, ValidateEmail("val@temporal.io"))
+
+	for _, email := range []string{"", "val", "val@", "Val <val@temporal.io>", " val@temporal.io"} {
+		err := ValidateEmail(email)
+		assert.Error(t, err, email)
+		assert.Equal(t, ErrorTypeInvalidRequest, applicationErrorType(err), email)
+	}
+}
+
+func TestValidateShippingAddress(t *testing.T) {
+	address := ShippingAddress{Name: "Val Temporal", Line1: "Hauptstraße 1", City: "Berlin", PostalCode: "10115", Country: "DE"}
+	assert.NoError(t, ValidateShippingAddress(address))
+
+	for _, change := range []func(a *ShippingAddress){
+		func(a *ShippingAddress) { a.Name = "" },
+		func(a *ShippingAddress) { a.Line1 = " " },
+		func(a *ShippingAddress) { a.City = "" },
+		func(a *ShippingAddress) { a.PostalCode = "" },
+		func(a *ShippingAddress) { a.Country = "" },
+		func(a *ShippingAddress) { a.Country = "Germany" },
+		func(a *ShippingAddress) { a.Country = "de" },
+		func(a *ShippingAddress) { a.Line2 = strings.Repeat("x", MaxAddressLength+1) },
+	} {
+		invalid := address
+		change(&invalid)
+		err := ValidateShippingAddress(invalid)
+		assert.Error(t, err, "%+v", invalid)
+		assert.Equal(t, ErrorTypeInvalidRequest, applicationErrorType(err), "%+v", invalid)
+	}
+}
+
+func TestValidateCheckoutNeedsShippingAddress(t *testing.T) {
+	cart := CartState{Status: CartOpen, Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}}
+	err := cart.validateCheckout(CheckoutSignal{Email: "val@temporal.io"})
+	assert.Equal(t, ErrorTypeInvalidRequest, applicationErrorType(err))
+
+	address := ShippingAddress{Name: "Val Temporal", Line1: "1 Main Street", City: "Bellevue", PostalCode: "98004", Country: "US"}
+	assert.NoError(t, cart.validateCheckout(CheckoutSignal{Email: "val@temporal.io", ShippingAddress: address}))
+}
+
+func TestShippingAddressLines(t *testing.T) {
+	us := ShippingAddress{Name: "Val Temporal", Line1: "1 Main Street", Line2: "Suite 2", City: "Bellevue", Region: "WA", PostalCode: "98004", Country: "US"}
+	assert.Equal(t, []string{"Val Temporal", "1 Main Street", "Suite 2", "Bellevue, WA 98004", "US"}, us.Lines())
+
+	de := ShippingAddress{Name: "Val Temporal", Line1: "Hauptstraße 1", City: "Berlin", PostalCode: "10115", Country: "DE"}
+	assert.Equal(t, []string{"Val Temporal", "Hauptstraße 1", "10115 Berlin", "DE"}, de.Lines())
+	assert.Equal(t, "Val Temporal, Hauptstraße 1, 10115 Berlin, DE", de.String())
+}
+
+func TestValidateItem(t *testing.T) {
+	ctx := context.Background()
+	catalog := DefaultCatalog()
+	assert.NoError(t, ValidateItem(ctx, catalog, CartItem{SKU: "IP12-64-BLK", Quantity: 1}))
+	assert.NoError(t, ValidateItem(ctx, catalog, CartItem{SKU: "IP12-64-BLK", Quantity: MaxQuantity}))
+
+	for _, item := range []CartItem{
+		{SKU: "IP12-64-BLK", Quantity: 0},
+		{SKU: "IP12-64-BLK", Quantity: -1},
+		{SKU: "IP12-64-BLK", Quantity: MaxQuantity + 1},
+		{SKU: "PIXEL-9-128", Quantity: 1},
+	} {
+		err := ValidateItem(ctx, catalog, item)
+		assert.Error(t, err, "%+v", item)
+		assert.Equal(t, ErrorTypeInvalidRequest, applicationErrorType(err), "%+v", item)
+	}
+}
+
+func TestValidateAddToCartLimitsLineQuantity(t *testing.T) {
+	cart := CartState{Status: CartOpen, Currency: "USD", Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: MaxQuantity - 1}}}
+
+	assert.NoError(t, cart.validateAddToCart(AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}}))
+	assert.Error(t, cart.validateAddToCart(AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}}))
+
+	cart.Status = CartCheckedOut
+	err := cart.validateAddToCart(AddToCartSignal{Item: CartItem{SKU: "IPSE-64-BLK", Quantity: 1}})
+	assert.Equal(t, ErrorTypeCartClosed, applicationErrorType(err))
+}
@@ -2,17 +2,28 @@
 package main
 
 import (
-	"log"
+	"context"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
+	"log"
 	"os"
 	"temporal-ecommerce/app"
 )
 
 var (
-	stripeKey     = os.Getenv("STRIPE_PRIVATE_KEY")
-	mailgunDomain = os.Getenv("MAILGUN_DOMAIN")
-	mailgunKey    = os.Getenv("MAILGUN_PRIVATE_KEY")
+	paymentProvider = os.Getenv("PAYMENT_PROVIDER")
+	stripeKey       = os.Getenv("STRIPE_PRIVATE_KEY")
+	emailProvider   = os.Getenv("EMAIL_PROVIDER")
+	emailFrom       = os.Getenv("EMAIL_FROM")
+	mailgunDomain   = os.Getenv("MAILGUN_DOMAIN")
+	mailgunKey      = os.Getenv("MAILGUN_PRIVATE_KEY")
+	smtpAddr        = os.Getenv("SMTP_ADDR")
+	smtpUsername    = os.Getenv("SMTP_USERNAME")
+	smtpPassword    = os.Getenv("SMTP_PASSWORD")
+	emailDir        = os.Getenv("EMAIL_DIR")
+	storeURL        = os.Getenv("STORE_URL")
+	catalogFile     = os.Getenv("CATALOG_FILE")
+	catalogDB       = os.Getenv("CATALOG_DB")
 )
 
 func main() {
@@ -25,30 +36,92 @@ func main() {
 	// This worker hosts both Worker and Activity functions
 	w := worker.New(c, "CART_TASK_QUEUE", worker.Options{})
 
-	if stripeKey == "" {
-		log.Fatalln("Must set STRIPE_PRIVATE_KEY environment variable")
+	var payments app.PaymentProvider
+	switch paymentProvider {
+	case "", "stripe":
+		if stripeKey == "" {
+			log.Fatalln("Must set STRIPE_PRIVATE_KEY environment variable")
+		}
+		payments = &app.StripeProvider{Key: stripeKey}
+	case "fake":
+		log.Println("Using in-memory fake payment provider")
+		payments = app.NewFakePaymentProvider()
+	default:
+		log.Fatalln("Unknown PAYMENT_PROVIDER", paymentProvider)
 	}
-	if mailgunDomain == "" {
-		log.Fatalln("Must set MAILGUN_DOMAIN environment variable")
+	var emails app.EmailSender
+	switch emailProvider {
+	case "", "mailgun":
+		if mailgunDomain == "" {
+			log.Fatalln("Must set MAILGUN_DOMAIN environment variable")
+		}
+		if mailgunKey == "" {
+			log.Fatalln("Must set MAILGUN_PRIVATE_KEY environment variable")
+		}
+		emails = &app.MailgunSender{Domain: mailgunDomain, Key: mailgunKey}
+	case "smtp":
+		if smtpAddr == "" {
+			log.Fatalln("Must set SMTP_ADDR environment variable")
+		}
+		if emailFrom == "" {
+			log.Fatalln("Must set EMAIL_FROM environment variable")
+		}
+		emails = &app.SMTPSender{Addr: smtpAddr, Username: smtpUsername, Password: smtpPassword, From: emailFrom}
+	case "file":
+		if emailDir == "" {
+			emailDir = "outbox"
+		}
+		if emailFrom == "" {
+			emailFrom = "noreply@localhost"
+		}
+		log.Println("Writing emails to", emailDir)
+		emails = &app.FileEmailSender{Dir: emailDir, From: emailFrom}
+	default:
+		log.Fatalln("Unknown EMAIL_PROVIDER", emailProvider)
 	}
-	if mailgunKey == "" {
-		log.Fatalln("Must set MAILGUN_PRIVATE_KEY environment variable")
+
+	catalog, err := app.OpenCatalog(context.Background(), catalogFile, catalogDB)
+	if err != nil {
+		log.Fatalln("unable to open catalog", err)
+	}
+	inventory, err := app.OpenInventory(context.Background(), catalog)
+	if err != nil {
+		log.Fatalln("unable to open inventory", err)
 	}
 
 	a := &app.Activities{
-		StripeKey: stripeKey,
-		MailgunDomain: mailgunDomain,
-		MailgunKey: mailgunKey,
+		Catalog:   catalog,
+		Payments:  payments,
+		Inventory: inventory,
+		// There is no carrier integration yet, so return labels are fake.
+		Labels:   app.NewFakeLabelProvider(),
+		Emails:   emails,
+		StoreURL: storeURL,
 	}
 
-	w.RegisterActivity(a.CreateStripeCharge)
+	w.RegisterActivity(a.LookupVariant)
+	w.RegisterActivity(a.AuthorizePayment)
+	w.RegisterActivity(a.CapturePayment)
+	w.RegisterActivity(a.CancelPayment)
+	w.RegisterActivity(a.RefundPayment)
+	w.RegisterActivity(a.ReserveInventory)
+	w.RegisterActivity(a.ReleaseInventory)
+	w.RegisterActivity(a.CommitInventory)
+	w.RegisterActivity(a.RestockInventory)
+	w.RegisterActivity(a.IssueReturnLabel)
 	w.RegisterActivity(a.SendAbandonedCartEmail)
+	w.RegisterActivity(a.SendOrderConfirmationEmail)
+	w.RegisterActivity(a.SendShippingNotificationEmail)
+	w.RegisterActivity(a.SendOrderCancellationEmail)
 
 	w.RegisterWorkflow(app.CartWorkflow)
+	w.RegisterWorkflow(app.OrderWorkflow)
+	w.RegisterWorkflow(app.ReturnWorkflow)
 	// Start listening to the Task Queue
 	err = w.Run(worker.InterruptCh())
 	if err != nil {
 		log.Fatalln("unable to start Worker", err)
 	}
 }
-// @@@SNIPEND
\ No newline at end of file
+
+// @@@SNIPEND
@@ -1,20 +1,58 @@
 package app
 
 import (
+	"errors"
+	"fmt"
 	"github.com/mitchellh/mapstructure"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 	"time"
 )
 
+type CartStatus string
+
+const (
+	CartOpen       CartStatus = "open"
+	CartCheckedOut CartStatus = "checked_out"
+	CartExpired    CartStatus = "expired"
+)
+
 type (
 	CartItem struct {
-		ProductId int
-		Quantity  int
+		// SKU is the variant of a product the item is for.
+		SKU      string
+		Quantity int
 	}
 
 	CartState struct {
-		Items []CartItem
-		Email string
+		Status CartStatus
+		Items  []CartItem
+		// Variants are the catalog entries of the items in the cart, as
+		// they were when each was first added. The cart is priced from
+		// them, so a catalog change never reprices it behind the
+		// shopper's back.
+		Variants []ProductVariant
+		Email    string
+		// ShippingAddress is set at checkout and copied to the order.
+		ShippingAddress ShippingAddress
+		Currency        string
+		// Locale is the language emails about the cart are written in.
+		Locale          string
+		CouponCode      string
+		CouponError     string
+		Pricing         Pricing
+		PaymentIntentID string
+		PaymentStatus   PaymentStatus
+		CheckoutAttempt int
+		Checkout        CheckoutOutcome
+		Order           Order
+		// Rejections are the most recent signals the cart refused.
+		Rejections []Rejection
+
+		// The abandoned cart reminders count from the last change to the
+		// cart. They are kept here so that they survive continue-as-new.
+		LastActivityAt time.Time
+		RemindersSent  int
 	}
 
 	UpdateCartMessage struct {
@@ -23,15 +61,43 @@ type (
 	}
 )
 
-var (
-	// Short timeout to consider shopping cart abandoned for development purposes.
-	abandonedCartTimeout = 10 * time.Second
-)
-
-func CartWorkflow(ctx workflow.Context, state CartState) error {
+func CartWorkflow(ctx workflow.Context, state CartState, config CartConfig) error {
 	// https://docs.temporal.io/docs/concepts/workflows/#workflows-have-options
 	logger := workflow.GetLogger(ctx)
 
+	// The config comes complete from whoever started the cart, see
+	// DefaultCartConfig.
+	if err := config.Validate(); err != nil {
+		return invalidRequest("invalid cart config: %s", err)
+	}
+
+	if state.Status == "" {
+		state.Status = CartOpen
+	}
+	if state.Currency == "" {
+		state.Currency = DefaultCurrency
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, ao)
+	cartID := workflow.GetInfo(ctx).WorkflowExecution.ID
+
+	var a *Activities
+
+	// A cart started with items needs their variants before it can be
+	// priced.
+	if err := state.lookupVariants(ctx, state.missingVariants()); err != nil {
+		logger.Error("Unable to look up variants", "Error", err)
+		return err
+	}
+	if err := state.reprice(); err != nil {
+		logger.Error("Unable to price cart", "Error", err)
+		return err
+	}
+
 	err := workflow.SetQueryHandler(ctx, "getCart", func(input []byte) (CartState, error) {
 		return state, nil
 	})
@@ -40,17 +106,182 @@ func CartWorkflow(ctx workflow.Context, state CartState) error {
 		return err
 	}
 
+	// If the cart is abandoned, give the units it holds back to the store.
+	defer func() {
+		if !errors.Is(ctx.Err(), workflow.ErrCanceled) {
+			return
+		}
+		ctx, _ := workflow.NewDisconnectedContext(ctx)
+		err := workflow.ExecuteActivity(ctx, a.ReleaseInventory, cartID).Get(ctx, nil)
+		if err != nil {
+			logger.Error("Error releasing inventory", "Error", err)
+		}
+	}()
+
 	addToCartChannel := workflow.GetSignalChannel(ctx, SignalChannels.ADD_TO_CART_CHANNEL)
 	removeFromCartChannel := workflow.GetSignalChannel(ctx, SignalChannels.REMOVE_FROM_CART_CHANNEL)
 	updateEmailChannel := workflow.GetSignalChannel(ctx, SignalChannels.UPDATE_EMAIL_CHANNEL)
 	checkoutChannel := workflow.GetSignalChannel(ctx, SignalChannels.CHECKOUT_CHANNEL)
+	applyCouponChannel := workflow.GetSignalChannel(ctx, SignalChannels.APPLY_COUPON_CHANNEL)
 	checkedOut := false
-	sentAbandonedCartEmail := false
 
-	var a *Activities
+	// Updates run in their own coroutines, with their own context, so only
+	// one change to the cart may be in flight at a time. Each finished update
+	// wakes the main loop below so that it restarts the abandoned cart timer.
+	lock := workflow.NewMutex(ctx)
+	updated := workflow.NewBufferedChannel(ctx, 1)
 
-	for {
-		selector := workflow.NewSelector(ctx)
+	addToCart := func(ctx workflow.Context, message AddToCartSignal) error {
+		if err := lock.Lock(ctx); err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		if err := state.validateAddToCart(message); err != nil {
+			return err
+		}
+		if _, ok := state.variant(message.Item.SKU); !ok {
+			if err := state.lookupVariants(ctx, []string{message.Item.SKU}); err != nil {
+				return err
+			}
+			// Only now can the cart tell whether the variant is sold in
+			// its currency.
+			if err := state.validateAddToCart(message); err != nil {
+				state.forgetVariant(message.Item.SKU)
+				return err
+			}
+		}
+
+		quantity := state.quantityOf(message.Item.SKU) + message.Item.Quantity
+		err := workflow.ExecuteActivity(ctx, a.ReserveInventory, cartID, message.Item.SKU, quantity).Get(ctx, nil)
+		if err != nil {
+			return updateError(err)
+		}
+
+		state.AddToCart(message.Item)
+		return state.reprice()
+	}
+
+	removeFromCart := func(ctx workflow.Context, message RemoveFromCartSignal) error {
+		if err := lock.Lock(ctx); err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		if err := state.validateRemoveFromCart(message); err != nil {
+			return err
+		}
+
+		state.RemoveFromCart(message.Item)
+		quantity := state.quantityOf(message.Item.SKU)
+		if quantity == 0 {
+			state.forgetVariant(message.Item.SKU)
+		}
+		if err := state.reprice(); err != nil {
+			return err
+		}
+
+		err := workflow.ExecuteActivity(ctx, a.ReserveInventory, cartID, message.Item.SKU, quantity).Get(ctx, nil)
+		if err != nil {
+			// The item is out of the cart either way. A hold that outlives
+			// it is released when the cart is checked out or abandoned.
+			logger.Error("Error releasing inventory", "SKU", message.Item.SKU, "Error", err)
+		}
+		return nil
+	}
+
+	checkout := func(ctx workflow.Context, message CheckoutSignal) error {
+		if err := lock.Lock(ctx); err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		if err := state.validateCheckout(message); err != nil {
+			return err
+		}
+		state.Email = message.Email
+		state.ShippingAddress = message.ShippingAddress
+
+		// The coupon may have expired since it was applied. Never charge
+		// a different total than the shopper last saw.
+		if state.CouponCode != "" {
+			if _, err := CheckCoupon(state.CouponCode, state, workflow.Now(ctx)); err != nil {
+				logger.Info("Coupon no longer valid at checkout", "Code", state.CouponCode, "Error", err)
+				state.CouponCode = ""
+				state.CouponError = err.Error()
+				if err := state.reprice(); err != nil {
+					return err
+				}
+				return temporal.NewNonRetryableApplicationError(err.Error(), ErrorTypeCouponInvalid, nil)
+			}
+		}
+
+		err := runCheckout(ctx, &state)
+		if err != nil {
+			return err
+		}
+
+		state.Status = CartCheckedOut
+		checkedOut = true
+		return nil
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateNames.ADD_TO_CART,
+		func(ctx workflow.Context, message AddToCartSignal) (CartState, error) {
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			defer updated.SendAsync(true)
+			if err := addToCart(ctx, message); err != nil {
+				return CartState{}, err
+			}
+			return state, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, message AddToCartSignal) error {
+				return state.validateAddToCart(message)
+			},
+		})
+	if err != nil {
+		return err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateNames.REMOVE_FROM_CART,
+		func(ctx workflow.Context, message RemoveFromCartSignal) (CartState, error) {
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			defer updated.SendAsync(true)
+			if err := removeFromCart(ctx, message); err != nil {
+				return CartState{}, err
+			}
+			return state, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, message RemoveFromCartSignal) error {
+				return state.validateRemoveFromCart(message)
+			},
+		})
+	if err != nil {
+		return err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateNames.CHECKOUT,
+		func(ctx workflow.Context, message CheckoutSignal) (CheckoutOutcome, error) {
+			ctx = workflow.WithActivityOptions(ctx, ao)
+			defer updated.SendAsync(true)
+			if err := checkout(ctx, message); err != nil {
+				return CheckoutOutcome{}, err
+			}
+			return state.Checkout, nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, message CheckoutSignal) error {
+				return state.validateCheckout(message)
+			},
+		})
+	if err != nil {
+		return err
+	}
+
+	// receiveSignals adds a case for every cart signal to a selector.
+	receiveSignals := func(selector workflow.Selector) {
 		selector.AddReceive(addToCartChannel, func(c workflow.ReceiveChannel, _ bool) {
 			var signal interface{}
 			c.Receive(ctx, &signal)
@@ -58,11 +289,13 @@ func CartWorkflow(ctx workflow.Context, state CartState) error {
 			var message AddToCartSignal
 			err := mapstructure.Decode(signal, &message)
 			if err != nil {
-				logger.Error("Invalid signal type %v", err)
+				state.reject(ctx, RouteTypes.ADD_TO_CART, invalidRequest("invalid signal: %s", err))
 				return
 			}
 
-			state.AddToCart(message.Item)
+			if err := addToCart(ctx, message); err != nil {
+				state.reject(ctx, RouteTypes.ADD_TO_CART, err)
+			}
 		})
 
 		selector.AddReceive(removeFromCartChannel, func(c workflow.ReceiveChannel, _ bool) {
@@ -72,11 +305,13 @@ func CartWorkflow(ctx workflow.Context, state CartState) error {
 			var message RemoveFromCartSignal
 			err := mapstructure.Decode(signal, &message)
 			if err != nil {
-				logger.Error("Invalid signal type %v", err)
+				state.reject(ctx, RouteTypes.REMOVE_FROM_CART, invalidRequest("invalid signal: %s", err))
 				return
 			}
 
-			state.RemoveFromCart(message.Item)
+			if err := removeFromCart(ctx, message); err != nil {
+				state.reject(ctx, RouteTypes.REMOVE_FROM_CART, err)
+			}
 		})
 
 		selector.AddReceive(updateEmailChannel, func(c workflow.ReceiveChannel, _ bool) {
@@ -86,52 +321,122 @@ func CartWorkflow(ctx workflow.Context, state CartState) error {
 			var message UpdateEmailSignal
 			err := mapstructure.Decode(signal, &message)
 			if err != nil {
-				logger.Error("Invalid signal type %v", err)
+				state.reject(ctx, RouteTypes.UPDATE_EMAIL, invalidRequest("invalid signal: %s", err))
+				return
+			}
+
+			if err := lock.Lock(ctx); err != nil {
+				return
+			}
+			defer lock.Unlock()
+
+			if err := state.validateUpdateEmail(message); err != nil {
+				state.reject(ctx, RouteTypes.UPDATE_EMAIL, err)
 				return
 			}
 
 			state.Email = message.Email
-			sentAbandonedCartEmail = false
 		})
 
-		selector.AddReceive(checkoutChannel, func(c workflow.ReceiveChannel, _ bool) {
+		selector.AddReceive(applyCouponChannel, func(c workflow.ReceiveChannel, _ bool) {
 			var signal interface{}
 			c.Receive(ctx, &signal)
 
-			var message CheckoutSignal
+			var message ApplyCouponSignal
 			err := mapstructure.Decode(signal, &message)
 			if err != nil {
-				logger.Error("Invalid signal type %v", err)
+				state.reject(ctx, RouteTypes.APPLY_COUPON, invalidRequest("invalid signal: %s", err))
 				return
 			}
 
-			state.Email = message.Email
+			if err := lock.Lock(ctx); err != nil {
+				return
+			}
+			defer lock.Unlock()
 
-			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: time.Minute,
+			if err := state.validateApplyCoupon(message); err != nil {
+				state.reject(ctx, RouteTypes.APPLY_COUPON, err)
+				return
 			}
 
-			ctx = workflow.WithActivityOptions(ctx, ao)
+			state.CouponCode = ""
+			state.CouponError = ""
+			if message.Code != "" {
+				promotion, err := CheckCoupon(message.Code, state, workflow.Now(ctx))
+				if err != nil {
+					logger.Info("Rejected coupon", "Code", message.Code, "Error", err)
+					state.CouponError = err.Error()
+				} else {
+					state.CouponCode = promotion.Code
+				}
+			}
+
+			if err := state.reprice(); err != nil {
+				logger.Error("Unable to price cart", "Error", err)
+			}
+		})
 
-			err = workflow.ExecuteActivity(ctx, a.CreateStripeCharge, state).Get(ctx, nil)
+		selector.AddReceive(checkoutChannel, func(c workflow.ReceiveChannel, _ bool) {
+			var signal interface{}
+			c.Receive(ctx, &signal)
+
+			var message CheckoutSignal
+			err := mapstructure.Decode(signal, &message)
 			if err != nil {
-				logger.Error("Error creating stripe charge: %v", err)
+				state.reject(ctx, RouteTypes.CHECKOUT, invalidRequest("invalid signal: %s", err))
 				return
 			}
 
-			checkedOut = true
+			if err := checkout(ctx, message); err != nil {
+				state.reject(ctx, RouteTypes.CHECKOUT, err)
+			}
 		})
+	}
 
-		if !sentAbandonedCartEmail && len(state.Items) > 0 {
-			selector.AddFuture(workflow.NewTimer(ctx, abandonedCartTimeout), func(f workflow.Future) {
-				sentAbandonedCartEmail = true
-				ao := workflow.ActivityOptions{
-					StartToCloseTimeout: time.Minute,
-				}
+	if state.LastActivityAt.IsZero() {
+		state.LastActivityAt = workflow.Now(ctx)
+	}
 
-				ctx = workflow.WithActivityOptions(ctx, ao)
+	for !checkedOut {
+		selector := workflow.NewSelector(ctx)
+		receiveSignals(selector)
 
-				err := workflow.ExecuteActivity(ctx, a.SendAbandonedCartEmail, state.Email).Get(ctx, nil)
+		selector.AddReceive(updated, func(c workflow.ReceiveChannel, _ bool) {
+			c.Receive(ctx, nil)
+		})
+
+		// The cart counts as abandoned once it has been left alone for
+		// config.AbandonedCartTimeout, which starts the reminders, and
+		// expires after config.TTL. The timers are restarted after every change.
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		abandoned := false
+		expired := false
+		selector.AddFuture(workflow.NewTimer(timerCtx, state.untilIdleFor(ctx, config.TTL)), func(f workflow.Future) {
+			if err := f.Get(ctx, nil); err != nil {
+				return
+			}
+			expired = true
+		})
+		if state.RemindersSent < len(config.Reminders) && len(state.Items) > 0 {
+			step := config.Reminders[state.RemindersSent]
+			wait := state.untilIdleFor(ctx, config.AbandonedCartTimeout+step.After)
+			selector.AddFuture(workflow.NewTimer(timerCtx, wait), func(f workflow.Future) {
+				if err := f.Get(ctx, nil); err != nil {
+					return
+				}
+				abandoned = true
+				state.RemindersSent++
+
+				reminder := AbandonedCartReminder{
+					CartID:     cartID,
+					Email:      state.Email,
+					Locale:     state.Locale,
+					Step:       state.RemindersSent,
+					Stage:      step.Stage,
+					CouponCode: step.CouponCode,
+					Pricing:    state.Pricing,
+				}
+				err := workflow.ExecuteActivity(ctx, a.SendAbandonedCartEmail, reminder).Get(ctx, nil)
 				if err != nil {
 					logger.Error("Error sending email %v", err)
 					return
@@ -139,20 +444,194 @@ func CartWorkflow(ctx workflow.Context, state CartState) error {
 			})
 		}
 
+		selector.AddReceive(ctx.Done(), func(c workflow.ReceiveChannel, _ bool) {})
+
 		selector.Select(ctx)
+		cancelTimer()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if expired {
+			return expireCart(ctx, &state, lock)
+		}
+		if !abandoned {
+			// Any change to the cart starts the reminders over.
+			state.LastActivityAt = workflow.Now(ctx)
+			state.RemindersSent = 0
+		}
+
+		if !checkedOut && shouldContinueAsNew(ctx, config) {
+			// Let running updates finish and handle every signal that has
+			// already arrived, so that no change to the cart is lost.
+			for {
+				err := workflow.Await(ctx, func() bool {
+					return workflow.AllHandlersFinished(ctx)
+				})
+				if err != nil {
+					return err
+				}
+
+				drain := workflow.NewSelector(ctx)
+				receiveSignals(drain)
+				if !drain.HasPending() {
+					break
+				}
+				drain.Select(ctx)
+			}
+
+			if !checkedOut {
+				logger.Info("Continuing cart as new", "HistoryLength", workflow.GetInfo(ctx).GetCurrentHistoryLength())
+				return workflow.NewContinueAsNewError(ctx, CartWorkflow, state, config)
+			}
+		}
+	}
+
+	return startOrder(ctx, &state)
+}
+
+// expireCart closes a cart that has been left alone for its TTL and returns
+// the units it holds to stock. It first waits for any update that is still
+// running, and if that update checked the cart out, starts the order
+// instead.
+func expireCart(ctx workflow.Context, state *CartState, lock workflow.Mutex) error {
+	if err := lock.Lock(ctx); err != nil {
+		return err
+	}
+	if state.Status != CartOpen {
+		lock.Unlock()
+		return startOrder(ctx, state)
+	}
+	state.Status = CartExpired
+	lock.Unlock()
 
-		if checkedOut {
-			break
+	workflow.GetLogger(ctx).Info("Cart expired", "LastActivityAt", state.LastActivityAt)
+
+	var a *Activities
+	cartID := workflow.GetInfo(ctx).WorkflowExecution.ID
+	err := workflow.ExecuteActivity(ctx, a.ReleaseInventory, cartID).Get(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Updates that arrive from now on are rejected since the cart is closed.
+	return workflow.Await(ctx, func() bool {
+		return workflow.AllHandlersFinished(ctx)
+	})
+}
+
+// untilIdleFor returns how long is left until the cart has gone d without a
+// change.
+func (state *CartState) untilIdleFor(ctx workflow.Context, d time.Duration) time.Duration {
+	return untilElapsed(ctx, state.LastActivityAt, d)
+}
+
+// shouldContinueAsNew reports whether the cart's history is long enough that
+// it should carry its state over to a fresh run.
+func shouldContinueAsNew(ctx workflow.Context, config CartConfig) bool {
+	info := workflow.GetInfo(ctx)
+	return info.GetContinueAsNewSuggested() ||
+		info.GetCurrentHistoryLength() >= config.MaxHistoryEvents ||
+		info.GetCurrentHistorySize() >= config.MaxHistoryBytes
+}
+
+// paymentIdempotencyKey derives the key for one payment operation. It only
+// depends on workflow state, so every retry of the activity sends the same
+// key while a new checkout attempt gets a fresh one.
+func paymentIdempotencyKey(ctx workflow.Context, attempt int, operation string) string {
+	workflowID := workflow.GetInfo(ctx).WorkflowExecution.ID
+	return fmt.Sprintf("%s/checkout-%d/%s", workflowID, attempt, operation)
+}
+
+// reprice refreshes the cart's price breakdown. It runs after every change
+// so that getCart and the payment activities always see the same numbers.
+func (state *CartState) reprice() error {
+	pricing, err := PriceCart(*state, DefaultPricingRules)
+	if err != nil {
+		return err
+	}
+
+	state.Pricing = pricing
+	return nil
+}
+
+// checkCurrency enforces that a cart only ever holds prices in one currency.
+func (state *CartState) checkCurrency(message AddToCartSignal) error {
+	if message.Currency != "" && message.Currency != state.Currency {
+		return fmt.Errorf("%w: cart is in %s, item was priced in %s", ErrCurrencyMismatch, state.Currency, message.Currency)
+	}
+
+	// A variant the cart has not looked up yet is checked once it has.
+	variant, ok := state.variant(message.Item.SKU)
+	if !ok {
+		return nil
+	}
+	if _, ok := variant.Price(state.Currency); !ok {
+		return fmt.Errorf("SKU %s is not sold in %s", message.Item.SKU, state.Currency)
+	}
+
+	return nil
+}
+
+// variant is the cart's copy of a variant it holds.
+func (state *CartState) variant(sku string) (ProductVariant, bool) {
+	for _, variant := range state.Variants {
+		if variant.SKU == sku {
+			return variant, true
+		}
+	}
+	return ProductVariant{}, false
+}
+
+// missingVariants lists the items the cart has no variant for.
+func (state *CartState) missingVariants() []string {
+	var missing []string
+	for _, item := range state.Items {
+		if _, ok := state.variant(item.SKU); !ok {
+			missing = append(missing, item.SKU)
 		}
 	}
+	return missing
+}
 
+// lookupVariants copies variants from the catalog into the cart.
+func (state *CartState) lookupVariants(ctx workflow.Context, skus []string) error {
+	var a *Activities
+	for _, sku := range skus {
+		var variant ProductVariant
+		err := workflow.ExecuteActivity(ctx, a.LookupVariant, sku).Get(ctx, &variant)
+		if err != nil {
+			return updateError(err)
+		}
+		state.Variants = append(state.Variants, variant)
+	}
 	return nil
 }
 
+// forgetVariant drops the cart's copy of a variant it no longer holds, so
+// that adding it again picks up the current catalog entry.
+func (state *CartState) forgetVariant(sku string) {
+	for i, variant := range state.Variants {
+		if variant.SKU == sku {
+			state.Variants = append(state.Variants[:i], state.Variants[i+1:]...)
+			return
+		}
+	}
+}
+
+func (state *CartState) quantityOf(sku string) int {
+	for _, item := range state.Items {
+		if item.SKU == sku {
+			return item.Quantity
+		}
+	}
+	return 0
+}
+
 // @@@SNIPSTART temporal-ecommerce-add-and-remove
 func (state *CartState) AddToCart(item CartItem) {
 	for i := range state.Items {
-		if state.Items[i].ProductId != item.ProductId {
+		if state.Items[i].SKU != item.SKU {
 			continue
 		}
 
@@ -165,7 +644,7 @@ func (state *CartState) AddToCart(item CartItem) {
 
 func (state *CartState) RemoveFromCart(item CartItem) {
 	for i := range state.Items {
-		if state.Items[i].ProductId != item.ProductId {
+		if state.Items[i].SKU != item.SKU {
 			continue
 		}
 
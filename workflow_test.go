@@ -2,25 +2,84 @@ package app
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
 
 	"time"
 )
 
+// testAddress is where test orders are shipped.
+var testAddress = ShippingAddress{
+	Name:       "Val Temporal",
+	Line1:      "1 Main Street",
+	City:       "Bellevue",
+	Region:     "WA",
+	PostalCode: "98004",
+	Country:    "US",
+}
+
+// defaultStock is the stock of the default catalog's variants.
+var defaultStock = CatalogStock(DefaultCatalog().products)
+
 type UnitTestSuite struct {
 	suite.Suite
 	testsuite.WorkflowTestSuite
 
-	env *testsuite.TestWorkflowEnvironment
+	env       *testsuite.TestWorkflowEnvironment
+	config    CartConfig
+	payments  *FakePaymentProvider
+	inventory *MemoryInventory
+
+	// Order confirmations sent so far, and the error sending the next one
+	// should fail with.
+	confirmations   []Order
+	confirmationErr error
+	// Shipping notifications sent so far.
+	shipments []Order
+	// Cancellation emails sent so far.
+	cancellations []Order
 }
 
 func (s *UnitTestSuite) SetupTest() {
 	s.env = s.NewTestWorkflowEnvironment()
+	s.config = DefaultCartConfig()
+	s.payments = NewFakePaymentProvider()
+	s.inventory = NewMemoryInventory(defaultStock)
+	s.env.RegisterActivity(&Activities{Catalog: DefaultCatalog(), Payments: s.payments, Inventory: s.inventory, Labels: NewFakeLabelProvider()})
+	s.env.RegisterWorkflow(OrderWorkflow)
+	s.env.RegisterWorkflow(ReturnWorkflow)
+
+	var a *Activities
+	s.confirmations = nil
+	s.confirmationErr = nil
+	s.env.OnActivity(a.SendOrderConfirmationEmail, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, order Order) error {
+			if s.confirmationErr != nil {
+				return s.confirmationErr
+			}
+			s.confirmations = append(s.confirmations, order)
+			return nil
+		}).Maybe()
+	s.shipments = nil
+	s.env.OnActivity(a.SendShippingNotificationEmail, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, order Order) error {
+			s.shipments = append(s.shipments, order)
+			return nil
+		}).Maybe()
+	s.cancellations = nil
+	s.env.OnActivity(a.SendOrderCancellationEmail, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, order Order) error {
+			s.cancellations = append(s.cancellations, order)
+			return nil
+		}).Maybe()
 }
 
 func (s *UnitTestSuite) AfterTest(suiteName, testName string) {
@@ -39,105 +98,732 @@ func (s *UnitTestSuite) Test_AddToCart() {
 
 		update := AddToCartSignal{
 			Route: RouteTypes.ADD_TO_CART,
-			Item:  CartItem{ProductId: 1, Quantity: 1},
+			Item:  CartItem{SKU: "IP12-64-BLK", Quantity: 1},
 		}
 		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, update)
 	}, time.Millisecond*1)
 
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
+
+	s.True(s.env.IsWorkflowCompleted())
+	// Checked once the workflow is done, as a signal gives no word of when
+	// the item has been reserved and added.
+	res, err := s.env.QueryWorkflow("getCart")
+	s.NoError(err)
+	err = res.Get(&cart)
+	s.NoError(err)
+	s.Equal(1, len(cart.Items))
+	s.Equal(1, len(cart.Pricing.Lines))
+	s.Equal(Money{Amount: 69900, Currency: "USD"}, cart.Pricing.Subtotal)
+	s.Equal(Money{Amount: 999, Currency: "USD"}, cart.Pricing.Shipping)
+	s.Equal(Money{Amount: 5068, Currency: "USD"}, cart.Pricing.Tax)
+	s.Equal(Money{Amount: 75967, Currency: "USD"}, cart.Pricing.Total)
+}
+
+func (s *UnitTestSuite) Test_RemoveFromCart() {
+	cart := CartState{Items: make([]CartItem, 0)}
+
+	// Add 2 items to the cart, then remove 1 once they are in it
 	s.env.RegisterDelayedCallback(func() {
-		res, err := s.env.QueryWorkflow("getCart")
-		s.NoError(err)
-		err = res.Get(&cart)
-		s.NoError(err)
-		s.Equal(1, len(cart.Items))
-	}, time.Millisecond*2)
+		s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}}, func() {
+			res, err := s.env.QueryWorkflow("getCart")
+			s.NoError(err)
+			err = res.Get(&cart)
+			s.NoError(err)
+			s.Require().Equal(1, len(cart.Items))
+			s.Equal(cart.Items[0].Quantity, 2)
 
-	s.env.ExecuteWorkflow(CartWorkflow, cart)
+			update := AddToCartSignal{
+				Route: RouteTypes.REMOVE_FROM_CART,
+				Item:  CartItem{SKU: "IP12-64-BLK", Quantity: 1},
+			}
+			s.env.SignalWorkflow(SignalChannels.REMOVE_FROM_CART_CHANNEL, update)
+		})
+	}, time.Millisecond*1)
+
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
 
 	s.True(s.env.IsWorkflowCompleted())
+
+	res, err := s.env.QueryWorkflow("getCart")
+	s.NoError(err)
+	err = res.Get(&cart)
+	s.NoError(err)
+	s.Equal(1, len(cart.Items))
+	s.Equal(cart.Items[0].Quantity, 1)
 }
 
-func (s *UnitTestSuite) Test_RemoveFromCart() {
+func (s *UnitTestSuite) Test_Checkout() {
 	cart := CartState{Items: make([]CartItem, 0)}
 
-	// Add 2 items to the cart
+	var a *Activities
+
+	s.env.OnActivity(a.AuthorizePayment, mock.Anything, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, _ CartState, _ string) (Payment, error) {
+			return Payment{ID: "pi_123", Status: PaymentAuthorized}, nil
+		})
+	s.env.OnActivity(a.CapturePayment, mock.Anything, "pi_123", mock.Anything).Return(
+		func(_ context.Context, id string, _ string) (Payment, error) {
+			return Payment{ID: id, Status: PaymentCaptured}, nil
+		})
+
+	// Add a product to the cart, then check out once it is in it
 	s.env.RegisterDelayedCallback(func() {
-		update := AddToCartSignal{
-			Route: RouteTypes.ADD_TO_CART,
-			Item:  CartItem{ProductId: 1, Quantity: 2},
-		}
-		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, update)
+		s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}}, func() {
+			res, err := s.env.QueryWorkflow("getCart")
+			s.NoError(err)
+			err = res.Get(&cart)
+			s.NoError(err)
+			s.Require().Equal(1, len(cart.Items))
+			s.Equal(cart.Items[0].Quantity, 1)
+
+			update := CheckoutSignal{
+				Route:           RouteTypes.CHECKOUT,
+				Email:           "test@temporal.io",
+				ShippingAddress: testAddress,
+			}
+			s.env.SignalWorkflow(SignalChannels.CHECKOUT_CHANNEL, update)
+		})
 	}, time.Millisecond*1)
 
-	// Remove 1 item from the cart
+	// The cart hands the order over and completes, while the order holds the
+	// payment until fulfillment is confirmed
 	s.env.RegisterDelayedCallback(func() {
-		res, err := s.env.QueryWorkflow("getCart")
-		s.NoError(err)
-		err = res.Get(&cart)
-		s.NoError(err)
-		s.Equal(len(cart.Items), 1)
-		s.Equal(cart.Items[0].Quantity, 2)
+		s.True(s.env.IsWorkflowCompleted())
 
-		update := AddToCartSignal{
-			Route: RouteTypes.REMOVE_FROM_CART,
-			Item:  CartItem{ProductId: 1, Quantity: 1},
+		order := s.queryOrder(s.orderID())
+		s.Equal("pi_123", order.PaymentID)
+		s.Equal(PaymentAuthorized, order.PaymentStatus)
+		s.Equal(OrderPlaced, order.Status)
+
+		update := FulfillmentSignal{
+			Route:          RouteTypes.FULFILLMENT,
+			Confirmed:      true,
+			Carrier:        "UPS",
+			TrackingNumber: "1Z999",
 		}
-		s.env.SignalWorkflow(SignalChannels.REMOVE_FROM_CART_CHANNEL, update)
-	}, time.Millisecond*2)
+		s.NoError(s.env.SignalWorkflowByID(order.ID, SignalChannels.FULFILLMENT_CHANNEL, update))
+	}, time.Minute)
+
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(OrderShipped, s.queryOrder(s.orderID()).Status)
+		s.NoError(s.env.SignalWorkflowByID(s.orderID(), SignalChannels.DELIVERY_CHANNEL, DeliverySignal{Route: RouteTypes.DELIVERY}))
+	}, time.Hour)
 
-	s.env.ExecuteWorkflow(CartWorkflow, cart)
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
 
 	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
 
 	res, err := s.env.QueryWorkflow("getCart")
 	s.NoError(err)
 	err = res.Get(&cart)
 	s.NoError(err)
-	s.Equal(1, len(cart.Items))
-	s.Equal(cart.Items[0].Quantity, 1)
+	s.Equal(CartCheckedOut, cart.Status)
+	s.True(cart.Checkout.Succeeded)
+	s.Equal(cart.Order.ID, cart.Checkout.OrderID)
+
+	order := s.queryOrder(cart.Order.ID)
+	s.Equal(PaymentCaptured, order.PaymentStatus)
+	s.Equal(OrderDelivered, order.Status)
+	s.Equal("1Z999", order.Shipment.TrackingNumber)
+	s.False(order.Shipment.DeliveredAt.IsZero())
+	s.Equal(1, len(s.confirmations))
+	s.Equal(1, len(s.shipments))
+	s.Equal(order.ID, s.shipments[0].ID)
+	s.Equal("UPS", s.shipments[0].Shipment.Carrier)
 }
 
-func (s *UnitTestSuite) Test_Checkout() {
+// orderID returns the ID of the order the cart was checked out into.
+func (s *UnitTestSuite) orderID() string {
+	res, err := s.env.QueryWorkflow("getCart")
+	s.NoError(err)
+	var cart CartState
+	s.NoError(res.Get(&cart))
+	return cart.Order.ID
+}
+
+func (s *UnitTestSuite) queryOrder(orderID string) Order {
+	res, err := s.env.QueryWorkflowByID(orderID, "getOrder")
+	s.NoError(err)
+	var order Order
+	s.NoError(res.Get(&order))
+	return order
+}
+
+func (s *UnitTestSuite) checkoutWithFakePaymentProvider(fulfillment *FulfillmentSignal) {
+	s.placeOrderWithFakePaymentProvider(func(orderID string) {
+		if fulfillment != nil {
+			s.NoError(s.env.SignalWorkflowByID(orderID, SignalChannels.FULFILLMENT_CHANNEL, *fulfillment))
+		}
+	})
+}
+
+// placeOrderWithFakePaymentProvider checks out two units of IP12-64-BLK and
+// calls afterCheckout with the order ID once the order has started.
+func (s *UnitTestSuite) placeOrderWithFakePaymentProvider(afterCheckout func(orderID string)) {
 	cart := CartState{Items: make([]CartItem, 0)}
 
-	var a *Activities
+	// Check out once the items are in the cart
+	s.env.RegisterDelayedCallback(func() {
+		s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}}, func() {
+			update := CheckoutSignal{
+				Route:           RouteTypes.CHECKOUT,
+				Email:           "test@temporal.io",
+				ShippingAddress: testAddress,
+			}
+			s.env.SignalWorkflow(SignalChannels.CHECKOUT_CHANNEL, update)
+		})
+	}, time.Millisecond*1)
 
-	s.env.OnActivity(a.CreateStripeCharge, mock.Anything, mock.Anything).Return(
-		func(_ context.Context, _ CartState) error {
-			return nil
+	s.env.RegisterDelayedCallback(func() {
+		afterCheckout(s.orderID())
+	}, time.Minute)
+
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *UnitTestSuite) Test_CheckoutWithFakePaymentProvider() {
+	s.checkoutWithFakePaymentProvider(&FulfillmentSignal{Route: RouteTypes.FULFILLMENT, Confirmed: true})
+
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	// 2 x 699.00 plus 7.25% tax, shipped for free
+	s.Equal(Money{Amount: 149936, Currency: "USD"}, charged[0].Amount)
+	s.Equal(PaymentCaptured, charged[0].Status)
+
+	// The sold units have left stock
+	available, err := s.inventory.Available(context.Background(), "IP12-64-BLK")
+	s.NoError(err)
+	s.Equal(defaultStock["IP12-64-BLK"]-2, available)
+}
+
+func (s *UnitTestSuite) Test_CheckoutFulfillmentRejected() {
+	s.checkoutWithFakePaymentProvider(&FulfillmentSignal{Route: RouteTypes.FULFILLMENT, Confirmed: false})
+
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	s.Equal(PaymentVoided, charged[0].Status)
+	s.Empty(s.shipments)
+	s.Equal(1, len(s.cancellations))
+	s.Equal("fulfillment was rejected", s.cancellations[0].CancelReason)
+
+	// The held units are back on sale
+	available, err := s.inventory.Available(context.Background(), "IP12-64-BLK")
+	s.NoError(err)
+	s.Equal(defaultStock["IP12-64-BLK"], available)
+}
+
+func (s *UnitTestSuite) Test_CancelOrder() {
+	s.placeOrderWithFakePaymentProvider(func(orderID string) {
+		cancel := CancelOrderSignal{Route: RouteTypes.CANCEL_ORDER, Reason: "changed my mind"}
+		s.NoError(s.env.SignalWorkflowByID(orderID, SignalChannels.CANCEL_ORDER_CHANNEL, cancel))
+	})
+
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	s.Equal(PaymentVoided, charged[0].Status)
+
+	s.Equal(1, len(s.cancellations))
+	cancelled := s.cancellations[0]
+	s.Equal(OrderCancelled, cancelled.Status)
+	s.Equal(PaymentVoided, cancelled.PaymentStatus)
+	s.Equal("changed my mind", cancelled.CancelReason)
+	s.True(cancelled.Refunded.IsZero())
+	s.False(cancelled.CancelledAt.IsZero())
+
+	available, err := s.inventory.Available(context.Background(), "IP12-64-BLK")
+	s.NoError(err)
+	s.Equal(defaultStock["IP12-64-BLK"], available)
+}
+
+func (s *UnitTestSuite) Test_CancelOrderWhilePaymentIsCaptured() {
+	s.placeOrderWithFakePaymentProvider(func(orderID string) {
+		// The cancellation is still waiting when the capture completes.
+		fulfillment := FulfillmentSignal{Route: RouteTypes.FULFILLMENT, Confirmed: true}
+		s.NoError(s.env.SignalWorkflowByID(orderID, SignalChannels.FULFILLMENT_CHANNEL, fulfillment))
+		cancel := CancelOrderSignal{Route: RouteTypes.CANCEL_ORDER}
+		s.NoError(s.env.SignalWorkflowByID(orderID, SignalChannels.CANCEL_ORDER_CHANNEL, cancel))
+	})
+
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	s.Equal(PaymentRefunded, charged[0].Status)
+	s.Equal(charged[0].Amount, charged[0].Refunded)
+	s.Empty(s.shipments)
+
+	s.Equal(1, len(s.cancellations))
+	s.Equal(charged[0].Amount, s.cancellations[0].Refunded)
+	s.Equal("cancelled by customer", s.cancellations[0].CancelReason)
+
+	available, err := s.inventory.Available(context.Background(), "IP12-64-BLK")
+	s.NoError(err)
+	s.Equal(defaultStock["IP12-64-BLK"], available)
+}
+
+func (s *UnitTestSuite) Test_CancelShippedOrderIsRejected() {
+	var orderID string
+	s.env.RegisterDelayedCallback(func() {
+		cancel := CancelOrderSignal{Route: RouteTypes.CANCEL_ORDER}
+		s.NoError(s.env.SignalWorkflowByID(orderID, SignalChannels.CANCEL_ORDER_CHANNEL, cancel))
+	}, time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		order := s.queryOrder(orderID)
+		s.Equal(OrderShipped, order.Status)
+		s.Equal(1, len(order.Rejections))
+		s.Equal(ErrorTypeOrderStatus, order.Rejections[0].Type)
+
+		s.NoError(s.env.SignalWorkflowByID(orderID, SignalChannels.DELIVERY_CHANNEL, DeliverySignal{Route: RouteTypes.DELIVERY}))
+	}, 2*time.Hour)
+
+	s.placeOrderWithFakePaymentProvider(func(id string) {
+		orderID = id
+		fulfillment := FulfillmentSignal{Route: RouteTypes.FULFILLMENT, Confirmed: true}
+		s.NoError(s.env.SignalWorkflowByID(orderID, SignalChannels.FULFILLMENT_CHANNEL, fulfillment))
+	})
+
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	s.Equal(PaymentCaptured, charged[0].Status)
+	s.Equal(1, len(s.shipments))
+	s.Empty(s.cancellations)
+}
+
+// placeDeliveredOrder checks out two units of IP12-64-BLK, ships and delivers
+// them, and calls afterDelivery with the order ID an hour after delivery.
+func (s *UnitTestSuite) placeDeliveredOrder(afterDelivery func(orderID string)) {
+	var orderID string
+	s.env.RegisterDelayedCallback(func() {
+		s.NoError(s.env.SignalWorkflowByID(orderID, SignalChannels.DELIVERY_CHANNEL, DeliverySignal{Route: RouteTypes.DELIVERY}))
+	}, time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		afterDelivery(orderID)
+	}, 2*time.Hour)
+
+	s.placeOrderWithFakePaymentProvider(func(id string) {
+		orderID = id
+		fulfillment := FulfillmentSignal{Route: RouteTypes.FULFILLMENT, Confirmed: true}
+		s.NoError(s.env.SignalWorkflowByID(orderID, SignalChannels.FULFILLMENT_CHANNEL, fulfillment))
+	})
+}
+
+func (s *UnitTestSuite) Test_ReturnOrder() {
+	var orderID string
+	var requested, tooMany *updateResult
+	s.env.RegisterDelayedCallback(func() {
+		returnID := orderID + "-RETURN-1"
+		approval := ReturnApprovalSignal{Route: RouteTypes.RETURN_APPROVAL, Approved: true}
+		s.NoError(s.env.SignalWorkflowByID(returnID, SignalChannels.RETURN_APPROVAL_CHANNEL, approval))
+	}, 3*time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		returnID := orderID + "-RETURN-1"
+		res, err := s.env.QueryWorkflowByID(returnID, "getReturn")
+		s.NoError(err)
+		var ret Return
+		s.NoError(res.Get(&ret))
+		s.Equal(ReturnLabelIssued, ret.Status)
+		s.NotEmpty(ret.Label.TrackingNumber)
+
+		receipt := ReturnReceiptSignal{Route: RouteTypes.RETURN_RECEIPT, Accepted: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}}
+		s.NoError(s.env.SignalWorkflowByID(returnID, SignalChannels.RETURN_RECEIPT_CHANNEL, receipt))
+	}, 4*time.Hour)
+
+	s.placeDeliveredOrder(func(id string) {
+		orderID = id
+		requested = s.updateByID(orderID, UpdateNames.REQUEST_RETURN, "return-1",
+			ReturnRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}, Reason: "too big"})
+		// One of the two units is already on its way back.
+		tooMany = s.updateByID(orderID, UpdateNames.REQUEST_RETURN, "return-2",
+			ReturnRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}})
+	})
+
+	s.True(requested.accepted)
+	s.NoError(requested.err)
+	s.False(tooMany.accepted)
+	s.Equal(ErrorTypeInvalidRequest, applicationErrorType(tooMany.err))
+
+	// 699.00 for the unit plus 7.25% tax
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	s.Equal(Money{Amount: 74968, Currency: "USD"}, charged[0].Refunded)
+
+	order := s.queryOrder(orderID)
+	s.Equal(1, len(order.Returns))
+	s.Equal(ReturnRefunded, order.Returns[0].Status)
+	s.Equal(charged[0].Refunded, order.Returns[0].Refund)
+	s.Equal(2, len(order.Ledger))
+	s.Equal(LedgerRefund, order.Ledger[1].Type)
+	s.Equal(order.Returns[0].ID, order.Ledger[1].Reference)
+	s.Equal(charged[0].Refunded, order.Refunded)
+
+	// One unit was sold and the other is back in stock
+	available, err := s.inventory.Available(context.Background(), "IP12-64-BLK")
+	s.NoError(err)
+	s.Equal(defaultStock["IP12-64-BLK"]-1, available)
+}
+
+func (s *UnitTestSuite) Test_RefundOrder() {
+	var orderID string
+	var items, amount, rest, tooMuch *updateResult
+	s.env.RegisterDelayedCallback(func() {
+		// More than the first two left
+		tooMuch = s.updateByID(orderID, UpdateNames.REFUND_ORDER, "refund-3",
+			RefundRequest{Amount: Money{Amount: 73969, Currency: "USD"}})
+	}, 3*time.Hour)
+	s.env.RegisterDelayedCallback(func() {
+		rest = s.updateByID(orderID, UpdateNames.REFUND_ORDER, "refund-4", RefundRequest{})
+	}, 4*time.Hour)
+
+	s.placeDeliveredOrder(func(id string) {
+		orderID = id
+		items = s.updateByID(orderID, UpdateNames.REFUND_ORDER, "refund-1",
+			RefundRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}, Reason: "scratched"})
+		amount = s.updateByID(orderID, UpdateNames.REFUND_ORDER, "refund-2",
+			RefundRequest{Amount: Money{Amount: 1000, Currency: "USD"}, Reason: "late delivery"})
+	})
+
+	s.NoError(items.err)
+	s.Equal(Money{Amount: 74968, Currency: "USD"}, items.result.(LedgerEntry).Amount)
+	s.NoError(amount.err)
+	s.Equal(Money{Amount: 1000, Currency: "USD"}, amount.result.(LedgerEntry).Amount)
+	s.NoError(rest.err)
+	// Everything else of the 1,499.36 charged
+	s.Equal(Money{Amount: 73968, Currency: "USD"}, rest.result.(LedgerEntry).Amount)
+	s.False(tooMuch.accepted)
+	s.Equal(ErrorTypeRefundExceeded, applicationErrorType(tooMuch.err))
+
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	s.Equal(PaymentRefunded, charged[0].Status)
+	s.Equal(charged[0].Amount, charged[0].Refunded)
+
+	order := s.queryOrder(orderID)
+	s.Equal(4, len(order.Ledger))
+	s.Equal(LedgerCharge, order.Ledger[0].Type)
+	s.Equal(charged[0].Amount, order.Ledger[0].Amount)
+	s.Equal(charged[0].Amount, order.charged())
+	s.Equal(charged[0].Amount, order.Refunded)
+	s.Equal(PaymentRefunded, order.PaymentStatus)
+}
+
+func (s *UnitTestSuite) Test_ReturnExpires() {
+	var orderID string
+	s.placeDeliveredOrder(func(id string) {
+		orderID = id
+		s.updateByID(orderID, UpdateNames.REQUEST_RETURN, "return-1",
+			ReturnRequest{Items: []CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}})
+	})
+
+	// Nobody decided, so the return was approved, but it never came back.
+	order := s.queryOrder(orderID)
+	s.Equal(1, len(order.Returns))
+	s.Equal(ReturnExpired, order.Returns[0].Status)
+	s.NotEmpty(order.Returns[0].Label.TrackingNumber)
+	s.Equal(2, order.unclaimed("IP12-64-BLK"))
+
+	charged := s.payments.Payments()
+	s.Equal(PaymentCaptured, charged[0].Status)
+	s.True(charged[0].Refunded.IsZero())
+}
+
+func (s *UnitTestSuite) Test_CheckoutAuthorizationExpires() {
+	s.checkoutWithFakePaymentProvider(nil)
+
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	s.Equal(PaymentVoided, charged[0].Status)
+}
+
+func (s *UnitTestSuite) Test_CheckoutInCartCurrency() {
+	cart := CartState{Items: make([]CartItem, 0), Currency: "EUR"}
+
+	// Check out once the items are in the cart
+	s.env.RegisterDelayedCallback(func() {
+		s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}, Currency: "EUR"}, func() {
+			update := CheckoutSignal{
+				Route:           RouteTypes.CHECKOUT,
+				Email:           "test@temporal.io",
+				ShippingAddress: testAddress,
+			}
+			s.env.SignalWorkflow(SignalChannels.CHECKOUT_CHANNEL, update)
 		})
+	}, time.Millisecond*1)
+
+	s.env.RegisterDelayedCallback(func() {
+		update := FulfillmentSignal{Route: RouteTypes.FULFILLMENT, Confirmed: true}
+		s.NoError(s.env.SignalWorkflowByID(s.orderID(), SignalChannels.FULFILLMENT_CHANNEL, update))
+	}, time.Minute)
+
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	// 2 x 809.00 plus 20% VAT
+	s.Equal(Money{Amount: 194160, Currency: "EUR"}, charged[0].Amount)
+}
+
+func (s *UnitTestSuite) Test_AddToCartRejectsOtherCurrency() {
+	cart := CartState{Items: make([]CartItem, 0), Currency: "EUR"}
 
-	// Add a product to the cart
 	s.env.RegisterDelayedCallback(func() {
 		update := AddToCartSignal{
-			Route: RouteTypes.ADD_TO_CART,
-			Item:  CartItem{ProductId: 1, Quantity: 1},
+			Route:    RouteTypes.ADD_TO_CART,
+			Item:     CartItem{SKU: "IP12-64-BLK", Quantity: 1},
+			Currency: "USD",
 		}
 		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, update)
 	}, time.Millisecond*1)
 
-	// Check out
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
+
+	res, err := s.env.QueryWorkflow("getCart")
+	s.NoError(err)
+	err = res.Get(&cart)
+	s.NoError(err)
+	s.Equal("EUR", cart.Currency)
+	s.Equal(0, len(cart.Items))
+}
+
+func (s *UnitTestSuite) Test_ApplyCoupon() {
+	cart := CartState{Items: make([]CartItem, 0)}
+	s.env.SetStartTime(time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC))
+
+	// An expired coupon is reported and not applied. The coupons are only
+	// sent once the item is in the cart.
+	s.env.RegisterDelayedCallback(func() {
+		s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}}, func() {
+			update := ApplyCouponSignal{Route: RouteTypes.APPLY_COUPON, Code: "LAUNCH2020"}
+			s.env.SignalWorkflow(SignalChannels.APPLY_COUPON_CHANNEL, update)
+
+			s.env.RegisterDelayedCallback(func() {
+				res, err := s.env.QueryWorkflow("getCart")
+				s.NoError(err)
+				err = res.Get(&cart)
+				s.NoError(err)
+				s.Equal("", cart.CouponCode)
+				s.Contains(cart.CouponError, "expired")
+				s.True(cart.Pricing.Discount.IsZero())
+
+				update := ApplyCouponSignal{Route: RouteTypes.APPLY_COUPON, Code: "save10"}
+				s.env.SignalWorkflow(SignalChannels.APPLY_COUPON_CHANNEL, update)
+			}, time.Millisecond*1)
+		})
+	}, time.Millisecond*1)
+
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
+
+	res, err := s.env.QueryWorkflow("getCart")
+	s.NoError(err)
+	err = res.Get(&cart)
+	s.NoError(err)
+	s.Equal("SAVE10", cart.CouponCode)
+	s.Equal("", cart.CouponError)
+	s.Equal(Money{Amount: 6990, Currency: "USD"}, cart.Pricing.Discount)
+}
+
+func (s *UnitTestSuite) Test_AddToCartReservesInventory() {
+	cart := CartState{Items: make([]CartItem, 0)}
+	var tooMany *updateResult
+
+	// Each step waits for the one before it, since a delayed callback can
+	// fire while the workflow is still reserving.
+	s.env.RegisterDelayedCallback(func() {
+		s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12PRO-128-GRA", Quantity: 4}}, func() {
+			available, err := s.inventory.Available(context.Background(), "IP12PRO-128-GRA")
+			s.NoError(err)
+			s.Equal(defaultStock["IP12PRO-128-GRA"]-4, available)
+
+			// More than is left in stock
+			tooMany = s.updateThen(UpdateNames.ADD_TO_CART, "add-2", AddToCartSignal{Item: CartItem{SKU: "IP12PRO-128-GRA", Quantity: available + 1}}, func() {
+				res, err := s.env.QueryWorkflow("getCart")
+				s.NoError(err)
+				s.NoError(res.Get(&cart))
+				s.Equal(4, cart.Items[0].Quantity)
+
+				s.updateThen(UpdateNames.REMOVE_FROM_CART, "remove-1", RemoveFromCartSignal{Item: CartItem{SKU: "IP12PRO-128-GRA", Quantity: 3}}, func() {
+					available, err := s.inventory.Available(context.Background(), "IP12PRO-128-GRA")
+					s.NoError(err)
+					s.Equal(defaultStock["IP12PRO-128-GRA"]-1, available)
+
+					s.env.CancelWorkflow()
+				})
+			})
+		})
+	}, time.Millisecond*1)
+
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
+
+	s.Require().NotNil(tooMany)
+	s.ErrorContains(tooMany.err, "out of stock")
+	// An abandoned cart gives back what it held
+	available, err := s.inventory.Available(context.Background(), "IP12PRO-128-GRA")
+	s.NoError(err)
+	s.Equal(defaultStock["IP12PRO-128-GRA"], available)
+}
+
+// failCheckout adds a line to a cart, checks out and returns the cart as it
+// stands after the checkout failed. The workflow is then cancelled, before
+// the abandoned cart email would go out.
+// failCheckout also returns how many units of IP12-64-BLK were available
+// once the checkout had failed, while the cart was still open.
+func (s *UnitTestSuite) failCheckout() (CartState, int) {
+	var cart CartState
+	var available int
+
+	// Check out once the items are in the cart
+	s.env.RegisterDelayedCallback(func() {
+		s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}}, func() {
+			update := CheckoutSignal{
+				Route:           RouteTypes.CHECKOUT,
+				Email:           "test@temporal.io",
+				ShippingAddress: testAddress,
+			}
+			s.env.SignalWorkflow(SignalChannels.CHECKOUT_CHANNEL, update)
+		})
+	}, time.Millisecond*1)
+
 	s.env.RegisterDelayedCallback(func() {
 		res, err := s.env.QueryWorkflow("getCart")
 		s.NoError(err)
-		err = res.Get(&cart)
+		s.NoError(res.Get(&cart))
+		available, err = s.inventory.Available(context.Background(), "IP12-64-BLK")
 		s.NoError(err)
-		s.Equal(len(cart.Items), 1)
-		s.Equal(cart.Items[0].Quantity, 1)
+		s.False(s.env.IsWorkflowCompleted())
+		s.env.CancelWorkflow()
+	}, s.config.AbandonedCartTimeout/2)
 
-		update := CheckoutSignal{
-			Route: RouteTypes.CHECKOUT,
-			Email: "test@temporal.io",
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+	s.True(s.env.IsWorkflowCompleted())
+	return cart, available
+}
+
+func (s *UnitTestSuite) Test_CheckoutPaymentDeclinedCompensates() {
+	s.payments.FailWith = func(op string) error {
+		if op == "authorize" {
+			return ErrPaymentDeclined
 		}
-		s.env.SignalWorkflow(SignalChannels.CHECKOUT_CHANNEL, update)
-	}, time.Millisecond*2)
+		return nil
+	}
 
-	// Workflow should be completed after checking out
-	s.env.RegisterDelayedCallback(func() {
-		s.True(s.env.IsWorkflowCompleted())
-	}, time.Millisecond*3)
+	cart, available := s.failCheckout()
 
-	s.env.ExecuteWorkflow(CartWorkflow, cart)
+	s.Equal(CartOpen, cart.Status)
+	s.Equal(1, cart.Checkout.Attempt)
+	s.False(cart.Checkout.Succeeded)
+	s.Equal(StepAuthorizePayment, cart.Checkout.FailedStep)
+	s.Equal([]string{StepReserveInventory}, cart.Checkout.Compensations)
+	s.Equal(0, len(s.payments.Payments()))
+	s.Equal(0, len(s.confirmations))
+
+	// The cart still holds its items
+	s.Equal(defaultStock["IP12-64-BLK"]-2, available)
+}
+
+func (s *UnitTestSuite) Test_CheckoutConfirmationFailureCompensates() {
+	s.confirmationErr = temporal.NewNonRetryableApplicationError("mail rejected", "MailRejected", nil)
+
+	cart, available := s.failCheckout()
+
+	s.Equal(CartOpen, cart.Status)
+	s.Equal(StepSendConfirmation, cart.Checkout.FailedStep)
+	s.Equal([]string{StepCreateOrder, StepAuthorizePayment, StepReserveInventory}, cart.Checkout.Compensations)
+	s.Equal(OrderCancelled, cart.Order.Status)
+	s.Equal(PaymentVoided, cart.PaymentStatus)
+
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	s.Equal(PaymentVoided, charged[0].Status)
+
+	// Compensation kept the holds the open cart had before checkout
+	s.Equal(defaultStock["IP12-64-BLK"]-2, available)
+	s.Equal([]CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}, cart.Items)
+}
+
+func (s *UnitTestSuite) Test_AuthorizePaymentDeclined() {
+	payments := NewFakePaymentProvider()
+	payments.FailWith = func(op string) error {
+		if op == "authorize" {
+			return errors.New("card declined")
+		}
+		return nil
+	}
+
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(&Activities{Payments: payments})
+
+	var a *Activities
+	cart := s.pricedCart(CartState{Items: []CartItem{{SKU: "IP12PRO-128-GRA", Quantity: 1}}, Email: "test@temporal.io", Currency: "USD"})
+	_, err := env.ExecuteActivity(a.AuthorizePayment, cart, "CART-1/checkout-1/authorize")
+	s.Error(err)
+	s.Equal(0, len(payments.Payments()))
+}
+
+func (s *UnitTestSuite) Test_AuthorizePaymentNothingToCharge() {
+	payments := NewFakePaymentProvider()
+
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(&Activities{Payments: payments})
+
+	var a *Activities
+	_, err := env.ExecuteActivity(a.AuthorizePayment, CartState{Currency: "USD"}, "CART-1/checkout-1/authorize")
+	s.Equal(ErrorTypeInvalidRequest, applicationErrorType(err))
+	var appErr *temporal.ApplicationError
+	s.True(errors.As(err, &appErr) && appErr.NonRetryable())
+	s.Equal(0, len(payments.Payments()))
+}
+
+func (s *UnitTestSuite) Test_CheckoutRetryIsIdempotent() {
+	// The first authorization and capture succeed at the provider but their
+	// responses are lost, so Temporal retries both activities.
+	lost := map[string]bool{}
+	s.payments.FailAfter = func(op string) error {
+		if lost[op] {
+			return nil
+		}
+		lost[op] = true
+		return errors.New("timeout")
+	}
+
+	s.checkoutWithFakePaymentProvider(&FulfillmentSignal{Route: RouteTypes.FULFILLMENT, Confirmed: true})
+
+	s.True(lost["authorize"])
+	s.True(lost["capture"])
+	charged := s.payments.Payments()
+	s.Equal(1, len(charged))
+	s.Equal(PaymentCaptured, charged[0].Status)
+}
+
+func (s *UnitTestSuite) Test_PaymentIdempotencyKeyReuse() {
+	payments := NewFakePaymentProvider()
+
+	env := s.NewTestActivityEnvironment()
+	env.RegisterActivity(&Activities{Payments: payments})
+
+	var a *Activities
+	cart := s.pricedCart(CartState{Items: []CartItem{{SKU: "IP12PRO-128-GRA", Quantity: 1}}, Email: "test@temporal.io", Currency: "USD"})
+	_, err := env.ExecuteActivity(a.AuthorizePayment, cart, "CART-1/checkout-1/authorize")
+	s.NoError(err)
+	_, err = env.ExecuteActivity(a.AuthorizePayment, cart, "CART-1/checkout-1/authorize")
+	s.NoError(err)
+	s.Equal(1, len(payments.Payments()))
+
+	// A different cart must not be charged under a key that was already used
+	cart.Items[0].Quantity = 2
+	cart = s.pricedCart(cart)
+	_, err = env.ExecuteActivity(a.AuthorizePayment, cart, "CART-1/checkout-1/authorize")
+	s.Error(err)
+	s.Equal(1, len(payments.Payments()))
+
+	// A new checkout attempt gets a new key and a new payment
+	_, err = env.ExecuteActivity(a.AuthorizePayment, cart, "CART-1/checkout-2/authorize")
+	s.NoError(err)
+	s.Equal(2, len(payments.Payments()))
 }
 
 func (s *UnitTestSuite) Test_AbandonedCart() {
@@ -147,8 +833,8 @@ func (s *UnitTestSuite) Test_AbandonedCart() {
 
 	sendTo := ""
 	s.env.OnActivity(a.SendAbandonedCartEmail, mock.Anything, mock.Anything).Return(
-		func(_ context.Context, _sendTo string) error {
-			sendTo = _sendTo
+		func(_ context.Context, reminder AbandonedCartReminder) error {
+			sendTo = reminder.Email
 			return nil
 		})
 
@@ -156,7 +842,7 @@ func (s *UnitTestSuite) Test_AbandonedCart() {
 	s.env.RegisterDelayedCallback(func() {
 		update := AddToCartSignal{
 			Route: RouteTypes.ADD_TO_CART,
-			Item:  CartItem{ProductId: 1, Quantity: 1},
+			Item:  CartItem{SKU: "IP12-64-BLK", Quantity: 1},
 		}
 		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, update)
 
@@ -170,9 +856,9 @@ func (s *UnitTestSuite) Test_AbandonedCart() {
 	// Wait for 10 mins and make sure abandoned cart email has been sent
 	s.env.RegisterDelayedCallback(func() {
 		s.Equal(sendTo, "abandoned_test@temporal.io")
-	}, abandonedCartTimeout+time.Millisecond*2)
+	}, s.config.AbandonedCartTimeout+time.Millisecond*2)
 
-	s.env.ExecuteWorkflow(CartWorkflow, cart)
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
 
 	s.True(s.env.IsWorkflowCompleted())
 	s.Equal(sendTo, "abandoned_test@temporal.io")
@@ -185,8 +871,8 @@ func (s *UnitTestSuite) Test_AbandonedCartWithoutEmail() {
 
 	sendTo := "initial value"
 	s.env.OnActivity(a.SendAbandonedCartEmail, mock.Anything, mock.Anything).Return(
-		func(_ context.Context, _sendTo string) error {
-			sendTo = _sendTo
+		func(_ context.Context, reminder AbandonedCartReminder) error {
+			sendTo = reminder.Email
 			return nil
 		})
 
@@ -194,7 +880,7 @@ func (s *UnitTestSuite) Test_AbandonedCartWithoutEmail() {
 	s.env.RegisterDelayedCallback(func() {
 		update := AddToCartSignal{
 			Route: RouteTypes.ADD_TO_CART,
-			Item:  CartItem{ProductId: 1, Quantity: 1},
+			Item:  CartItem{SKU: "IP12-64-BLK", Quantity: 1},
 		}
 		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, update)
 	}, time.Millisecond*1)
@@ -202,11 +888,554 @@ func (s *UnitTestSuite) Test_AbandonedCartWithoutEmail() {
 	// Wait for 10 mins and make sure abandoned cart email was not sent
 	s.env.RegisterDelayedCallback(func() {
 		s.Equal(sendTo, "")
-	}, abandonedCartTimeout+time.Millisecond*2)
+	}, s.config.AbandonedCartTimeout+time.Millisecond*2)
+
+	s.env.ExecuteWorkflow(CartWorkflow, cart, s.config)
+
+	s.True(s.env.IsWorkflowCompleted())
+}
+
+func (s *UnitTestSuite) Test_AbandonedCartReminderCampaign() {
+	var a *Activities
+	var reminders []AbandonedCartReminder
+	s.env.OnActivity(a.SendAbandonedCartEmail, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, reminder AbandonedCartReminder) error {
+			reminders = append(reminders, reminder)
+			return nil
+		})
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, AddToCartSignal{Route: RouteTypes.ADD_TO_CART, Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}})
+		s.env.SignalWorkflow(SignalChannels.UPDATE_EMAIL_CHANNEL, UpdateEmailSignal{Route: RouteTypes.UPDATE_EMAIL, Email: "test@temporal.io"})
+	}, time.Millisecond*1)
+
+	// Coming back to the cart after the first reminder starts the campaign over
+	comeback := s.config.AbandonedCartTimeout + time.Hour
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(1, len(reminders))
+		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, AddToCartSignal{Route: RouteTypes.ADD_TO_CART, Item: CartItem{SKU: "IPSE-64-BLK", Quantity: 1}})
+	}, comeback)
+
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(2, len(reminders))
+	}, comeback+s.config.AbandonedCartTimeout+s.config.Reminders[1].After-time.Minute)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0), Locale: "de"}, s.config)
+
+	// The cart expires after the campaign has run its course
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	stages := make([]ReminderStage, 0, len(reminders))
+	for _, reminder := range reminders {
+		s.Equal("test@temporal.io", reminder.Email)
+		s.Equal("de", reminder.Locale)
+		stages = append(stages, reminder.Stage)
+	}
+	s.Equal([]ReminderStage{ReminderFirst, ReminderFirst, ReminderDiscount, ReminderFinal}, stages)
+	s.Equal("SAVE10", reminders[2].CouponCode)
+	s.Equal(2, len(reminders[3].Pricing.Lines))
+}
+
+func (s *UnitTestSuite) Test_InvalidSignalsAreRejected() {
+	var cart CartState
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, AddToCartSignal{Route: RouteTypes.ADD_TO_CART, Item: CartItem{SKU: "IP12-64-BLK", Quantity: -3}})
+		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, AddToCartSignal{Route: RouteTypes.ADD_TO_CART, Item: CartItem{SKU: "PIXEL-9-128", Quantity: 1}})
+		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, "not an item")
+		s.env.SignalWorkflow(SignalChannels.UPDATE_EMAIL_CHANNEL, UpdateEmailSignal{Route: RouteTypes.UPDATE_EMAIL, Email: "not-an-email"})
+		s.env.SignalWorkflow(SignalChannels.REMOVE_FROM_CART_CHANNEL, RemoveFromCartSignal{Route: RouteTypes.REMOVE_FROM_CART, Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}})
+	}, time.Millisecond*1)
+
+	s.env.RegisterDelayedCallback(func() {
+		res, err := s.env.QueryWorkflow("getCart")
+		s.NoError(err)
+		s.NoError(res.Get(&cart))
+		s.env.CancelWorkflow()
+	}, time.Millisecond*2)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.Empty(cart.Items)
+	s.Empty(cart.Email)
+
+	commands := make([]string, 0, len(cart.Rejections))
+	for _, rejection := range cart.Rejections {
+		s.Equal(ErrorTypeInvalidRequest, rejection.Type)
+		s.NotEmpty(rejection.Reason)
+		commands = append(commands, rejection.Command)
+	}
+	// Looking up the unknown SKU lets the other channels go first
+	s.ElementsMatch([]string{
+		RouteTypes.ADD_TO_CART,
+		RouteTypes.ADD_TO_CART,
+		RouteTypes.ADD_TO_CART,
+		RouteTypes.UPDATE_EMAIL,
+		RouteTypes.REMOVE_FROM_CART,
+	}, commands)
+}
+
+func (s *UnitTestSuite) Test_CartKeepsCatalogPrices() {
+	// The catalog raises the price of IP12-64-BLK once the cart has it
+	price := Money{Amount: 69900, Currency: "USD"}
+	var a *Activities
+	s.env.OnActivity(a.LookupVariant, mock.Anything, "IP12-64-BLK").Return(
+		func(_ context.Context, sku string) (ProductVariant, error) {
+			return ProductVariant{SKU: sku, ProductId: 1, Name: "iPhone 12 64GB Black", Prices: []Money{price}}, nil
+		})
+
+	var first, again, removed, readded *updateResult
+	s.env.RegisterDelayedCallback(func() {
+		first = s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}}, func() {
+			price = Money{Amount: 74900, Currency: "USD"}
+			again = s.updateThen(UpdateNames.ADD_TO_CART, "add-2", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}}, func() {
+				removed = s.updateThen(UpdateNames.REMOVE_FROM_CART, "remove-1", RemoveFromCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}}, func() {
+					readded = s.updateThen(UpdateNames.ADD_TO_CART, "add-3", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}}, func() {
+						s.env.CancelWorkflow()
+					})
+				})
+			})
+		})
+	}, time.Millisecond*1)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.NoError(first.err)
+	s.NoError(again.err)
+	cart := again.result.(CartState)
+	s.Equal(Money{Amount: 139800, Currency: "USD"}, cart.Pricing.Subtotal)
+
+	// Once it has left the cart, it comes back at the new price
+	s.NoError(removed.err)
+	s.Empty(removed.result.(CartState).Variants)
+	s.NoError(readded.err)
+	cart = readded.result.(CartState)
+	s.Equal(Money{Amount: 74900, Currency: "USD"}, cart.Pricing.Subtotal)
+}
+
+func (s *UnitTestSuite) Test_CartKeepsDeletedProduct() {
+	// The iPhone 12 is deleted from the catalog once the cart has one
+	deleted := false
+	var a *Activities
+	s.env.OnActivity(a.LookupVariant, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, sku string) (ProductVariant, error) {
+			if deleted {
+				return ProductVariant{}, invalidRequest("unknown SKU %q", sku)
+			}
+			return ProductVariant{SKU: sku, ProductId: 1, Name: "iPhone 12", Prices: []Money{{Amount: 69900, Currency: "USD"}}}, nil
+		})
+
+	var first, more, other *updateResult
+	s.env.RegisterDelayedCallback(func() {
+		first = s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}}, func() {
+			deleted = true
+			more = s.updateThen(UpdateNames.ADD_TO_CART, "add-2", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}}, func() {
+				other = s.updateThen(UpdateNames.ADD_TO_CART, "add-3", AddToCartSignal{Item: CartItem{SKU: "IPSE-64-BLK", Quantity: 1}}, func() {
+					s.env.CancelWorkflow()
+				})
+			})
+		})
+	}, time.Millisecond*1)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.NoError(first.err)
+	s.NoError(more.err)
+	s.Equal(Money{Amount: 139800, Currency: "USD"}, more.result.(CartState).Pricing.Subtotal)
+	s.Equal(ErrorTypeInvalidRequest, applicationErrorType(other.err))
+}
+
+func (s *UnitTestSuite) Test_AddProductNotSoldInCurrency() {
+	var a *Activities
+	s.env.OnActivity(a.LookupVariant, mock.Anything, "PIXEL-9-128").Return(
+		ProductVariant{SKU: "PIXEL-9-128", ProductId: 7, Name: "Pixel 9 128GB", Prices: []Money{{Amount: 79900, Currency: "USD"}}}, nil)
+
+	var added *updateResult
+	s.env.RegisterDelayedCallback(func() {
+		added = s.update(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "PIXEL-9-128", Quantity: 1}})
+	}, time.Millisecond*1)
+	s.env.RegisterDelayedCallback(func() {
+		s.env.CancelWorkflow()
+	}, time.Millisecond*2)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0), Currency: "EUR"}, s.config)
+
+	s.True(added.accepted)
+	s.Equal(ErrorTypeInvalidRequest, applicationErrorType(added.err))
+
+	res, err := s.env.QueryWorkflow("getCart")
+	s.NoError(err)
+	var cart CartState
+	s.NoError(res.Get(&cart))
+	s.Empty(cart.Items)
+	s.Empty(cart.Variants)
+}
+
+func (s *UnitTestSuite) Test_AddVariantsOfOneProduct() {
+	var graphite, bigger, tooMany *updateResult
+	// Each add is sent once the one before it has completed, so the lines
+	// keep this order.
+	s.env.RegisterDelayedCallback(func() {
+		graphite = s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12PRO-128-GRA", Quantity: 1}}, func() {
+			bigger = s.updateThen(UpdateNames.ADD_TO_CART, "add-2", AddToCartSignal{Item: CartItem{SKU: "IP12PRO-256-GRA", Quantity: 1}}, func() {
+				// Each variant has its own stock
+				tooMany = s.updateThen(UpdateNames.ADD_TO_CART, "add-3", AddToCartSignal{Item: CartItem{SKU: "IP12PRO-256-GRA", Quantity: defaultStock["IP12PRO-256-GRA"]}}, func() {
+					s.env.CancelWorkflow()
+				})
+			})
+		})
+	}, time.Millisecond*1)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.NoError(graphite.err)
+	s.NoError(bigger.err)
+	cart := bigger.result.(CartState)
+	s.Equal(2, len(cart.Pricing.Lines))
+	s.Equal("iPhone 12 Pro 128GB Graphite", cart.Pricing.Lines[0].Name)
+	s.Equal(Money{Amount: 99900, Currency: "USD"}, cart.Pricing.Lines[0].UnitPrice)
+	s.Equal("iPhone 12 Pro 256GB Graphite", cart.Pricing.Lines[1].Name)
+	s.Equal(Money{Amount: 109900, Currency: "USD"}, cart.Pricing.Lines[1].UnitPrice)
+	s.Equal(cart.Pricing.Lines[0].ProductId, cart.Pricing.Lines[1].ProductId)
+
+	s.Equal(ErrorTypeOutOfStock, applicationErrorType(tooMany.err))
+}
+
+func (s *UnitTestSuite) Test_ContinueAsNew() {
+	s.env.SetStartTime(time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC))
+	s.config.MaxHistoryEvents = 500
+	s.env.SetCurrentHistoryLength(500)
+
+	// Everything sent at once is handled before the cart continues as new
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, AddToCartSignal{Route: RouteTypes.ADD_TO_CART, Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}})
+		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, AddToCartSignal{Route: RouteTypes.ADD_TO_CART, Item: CartItem{SKU: "IPSE-64-BLK", Quantity: 1}})
+		s.env.SignalWorkflow(SignalChannels.UPDATE_EMAIL_CHANNEL, UpdateEmailSignal{Route: RouteTypes.UPDATE_EMAIL, Email: "test@temporal.io"})
+	}, time.Millisecond*1)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.True(s.env.IsWorkflowCompleted())
+	var continueAsNew *workflow.ContinueAsNewError
+	s.True(errors.As(s.env.GetWorkflowError(), &continueAsNew))
+
+	var cart CartState
+	var config CartConfig
+	s.NoError(converter.GetDefaultDataConverter().FromPayloads(continueAsNew.Input, &cart, &config))
+	s.Equal(s.config, config)
+	s.Equal([]CartItem{{SKU: "IP12-64-BLK", Quantity: 1}, {SKU: "IPSE-64-BLK", Quantity: 1}}, cart.Items)
+	s.Equal("test@temporal.io", cart.Email)
+	s.Equal(time.Date(2025, time.June, 1, 0, 0, 0, int(time.Millisecond), time.UTC), cart.LastActivityAt.UTC())
+	s.Equal(0, cart.RemindersSent)
+}
+
+func (s *UnitTestSuite) Test_ContinuedCartKeepsAbandonedCartTimer() {
+	start := time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+	s.env.SetStartTime(start)
+
+	var a *Activities
+	sent := 0
+	s.env.OnActivity(a.SendAbandonedCartEmail, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, _ AbandonedCartReminder) error {
+			sent++
+			return nil
+		})
+
+	// The previous run saw its last change 8s before it continued as new,
+	// so only the rest of the timeout is left.
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(0, sent)
+	}, s.config.AbandonedCartTimeout-8*time.Second-time.Millisecond)
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(1, sent)
+		s.env.CancelWorkflow()
+	}, s.config.AbandonedCartTimeout-8*time.Second+time.Millisecond)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{
+		Status:         CartOpen,
+		Items:          []CartItem{{SKU: "IP12-64-BLK", Quantity: 1}},
+		Email:          "test@temporal.io",
+		LastActivityAt: start.Add(-8 * time.Second),
+	}, s.config)
+
+	s.Equal(1, sent)
+}
+
+func (s *UnitTestSuite) Test_CartConfig() {
+	s.config.AbandonedCartTimeout = time.Hour
+	s.config.Reminders = []ReminderStep{{After: 0, Stage: ReminderFinal}}
+	s.config.TTL = 24 * time.Hour
+
+	var a *Activities
+	var reminders []AbandonedCartReminder
+	s.env.OnActivity(a.SendAbandonedCartEmail, mock.Anything, mock.Anything).Return(
+		func(_ context.Context, reminder AbandonedCartReminder) error {
+			reminders = append(reminders, reminder)
+			return nil
+		})
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, AddToCartSignal{Route: RouteTypes.ADD_TO_CART, Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}})
+	}, time.Millisecond*1)
+
+	s.env.RegisterDelayedCallback(func() {
+		s.Empty(reminders)
+	}, time.Hour-time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal(1, len(reminders))
+	}, time.Hour+time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		s.False(s.env.IsWorkflowCompleted())
+	}, 24*time.Hour-time.Minute)
 
-	s.env.ExecuteWorkflow(CartWorkflow, cart)
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
 
+	// The cart expires after its own TTL rather than the default one
 	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal(1, len(reminders))
+	s.Equal(ReminderFinal, reminders[0].Stage)
+}
+
+func (s *UnitTestSuite) Test_CartConfigIncomplete() {
+	// The worker fills in nothing, so a config with a setting left out is
+	// turned away
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, CartConfig{TTL: time.Hour})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.Equal(ErrorTypeInvalidRequest, applicationErrorType(s.env.GetWorkflowError()))
+}
+
+func (s *UnitTestSuite) Test_CartExpires() {
+	var a *Activities
+	s.env.OnActivity(a.SendAbandonedCartEmail, mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SignalChannels.ADD_TO_CART_CHANNEL, AddToCartSignal{Route: RouteTypes.ADD_TO_CART, Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}})
+	}, time.Millisecond*1)
+
+	// Activity restarts the clock
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(SignalChannels.UPDATE_EMAIL_CHANNEL, UpdateEmailSignal{Route: RouteTypes.UPDATE_EMAIL, Email: "test@temporal.io"})
+	}, s.config.TTL-time.Hour)
+
+	s.env.RegisterDelayedCallback(func() {
+		s.False(s.env.IsWorkflowCompleted())
+	}, s.config.TTL+time.Hour)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	res, err := s.env.QueryWorkflow("getCart")
+	s.NoError(err)
+	var cart CartState
+	s.NoError(res.Get(&cart))
+	s.Equal(CartExpired, cart.Status)
+
+	// The expired cart's units are back on sale
+	available, err := s.inventory.Available(context.Background(), "IP12-64-BLK")
+	s.NoError(err)
+	s.Equal(defaultStock["IP12-64-BLK"], available)
+
+	// Later changes are turned away
+	s.Equal(ErrorTypeCartExpired, applicationErrorType(cart.checkOpen()))
+}
+
+func (s *UnitTestSuite) Test_AddToCartUpdate() {
+	var added, outOfStock, invalid *updateResult
+
+	s.env.RegisterDelayedCallback(func() {
+		added = s.update(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}})
+	}, time.Millisecond*1)
+
+	s.env.RegisterDelayedCallback(func() {
+		outOfStock = s.update(UpdateNames.ADD_TO_CART, "add-2", AddToCartSignal{Item: CartItem{SKU: "IP12PRO-128-GRA", Quantity: defaultStock["IP12PRO-128-GRA"] + 1}})
+	}, time.Millisecond*2)
+
+	s.env.RegisterDelayedCallback(func() {
+		invalid = s.update(UpdateNames.ADD_TO_CART, "add-3", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 0}})
+	}, time.Millisecond*3)
+
+	s.env.RegisterDelayedCallback(func() {
+		s.env.CancelWorkflow()
+	}, time.Millisecond*4)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.True(added.accepted)
+	s.NoError(added.err)
+	cart := added.result.(CartState)
+	s.Equal([]CartItem{{SKU: "IP12-64-BLK", Quantity: 2}}, cart.Items)
+	s.Equal(Money{Amount: 149936, Currency: "USD"}, cart.Pricing.Total)
+
+	// Accepted, but the reservation failed
+	s.True(outOfStock.accepted)
+	s.Equal(ErrorTypeOutOfStock, applicationErrorType(outOfStock.err))
+
+	// Rejected before it reached the cart
+	s.False(invalid.accepted)
+	s.Equal(ErrorTypeInvalidRequest, applicationErrorType(invalid.err))
+}
+
+func (s *UnitTestSuite) Test_RemoveFromCartUpdate() {
+	var removed, missing *updateResult
+
+	s.env.RegisterDelayedCallback(func() {
+		s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}}, func() {
+			removed = s.updateThen(UpdateNames.REMOVE_FROM_CART, "remove-1", RemoveFromCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}}, func() {
+				missing = s.updateThen(UpdateNames.REMOVE_FROM_CART, "remove-2", RemoveFromCartSignal{Item: CartItem{SKU: "IP11-64-BLK", Quantity: 1}}, func() {
+					s.env.CancelWorkflow()
+				})
+			})
+		})
+	}, time.Millisecond*1)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.NoError(removed.err)
+	s.Equal([]CartItem{{SKU: "IP12-64-BLK", Quantity: 1}}, removed.result.(CartState).Items)
+	s.False(missing.accepted)
+	s.Equal(ErrorTypeInvalidRequest, applicationErrorType(missing.err))
+}
+
+func (s *UnitTestSuite) Test_CheckoutUpdate() {
+	var empty, checkout, again *updateResult
+
+	// Each step waits for the one before it. The cart completes right after
+	// checking out, so the add is sent as soon as the checkout has
+	// finished, before the cart gets to complete
+	s.env.RegisterDelayedCallback(func() {
+		empty = s.updateThen(UpdateNames.CHECKOUT, "checkout-1", CheckoutSignal{Email: "test@temporal.io", ShippingAddress: testAddress}, func() {
+			s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}}, func() {
+				checkout = s.updateThen(UpdateNames.CHECKOUT, "checkout-2", CheckoutSignal{Email: "test@temporal.io", ShippingAddress: testAddress}, func() {
+					again = s.update(UpdateNames.ADD_TO_CART, "add-2", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 1}})
+				})
+			})
+		})
+	}, time.Millisecond*1)
+
+	s.env.RegisterDelayedCallback(func() {
+		// The order is shipped where the shopper asked at checkout
+		s.Equal(testAddress, s.queryOrder(s.orderID()).ShippingAddress)
+		s.NoError(s.env.SignalWorkflowByID(s.orderID(), SignalChannels.FULFILLMENT_CHANNEL, FulfillmentSignal{Route: RouteTypes.FULFILLMENT, Confirmed: true}))
+	}, time.Minute)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+
+	s.False(empty.accepted)
+	s.Equal(ErrorTypeInvalidRequest, applicationErrorType(empty.err))
+
+	s.NoError(checkout.err)
+	outcome := checkout.result.(CheckoutOutcome)
+	s.True(outcome.Succeeded)
+	s.NotEmpty(outcome.OrderID)
+
+	// The add arrives once the cart is checked out, so it is never accepted
+	s.False(again.accepted)
+	s.Equal(ErrorTypeCartClosed, applicationErrorType(again.err))
+}
+
+func (s *UnitTestSuite) Test_CheckoutUpdatePaymentDeclined() {
+	s.payments.FailWith = func(op string) error {
+		if op == "authorize" {
+			return ErrPaymentDeclined
+		}
+		return nil
+	}
+	var checkout *updateResult
+
+	// The cart is only cancelled once the checkout and its compensations
+	// have finished.
+	s.env.RegisterDelayedCallback(func() {
+		s.updateThen(UpdateNames.ADD_TO_CART, "add-1", AddToCartSignal{Item: CartItem{SKU: "IP12-64-BLK", Quantity: 2}}, func() {
+			checkout = s.updateThen(UpdateNames.CHECKOUT, "checkout-1", CheckoutSignal{Email: "test@temporal.io", ShippingAddress: testAddress}, func() {
+				s.env.CancelWorkflow()
+			})
+		})
+	}, time.Millisecond*1)
+
+	s.env.ExecuteWorkflow(CartWorkflow, CartState{Items: make([]CartItem, 0)}, s.config)
+
+	s.True(checkout.accepted)
+	var appErr *temporal.ApplicationError
+	s.True(errors.As(checkout.err, &appErr))
+	s.Equal(ErrorTypePaymentDeclined, appErr.Type())
+
+	var outcome CheckoutOutcome
+	s.NoError(appErr.Details(&outcome))
+	s.Equal(StepAuthorizePayment, outcome.FailedStep)
+	s.Equal([]string{StepReserveInventory}, outcome.Compensations)
+}
+
+type updateResult struct {
+	accepted bool
+	result   interface{}
+	err      error
+}
+
+// update sends a workflow update and records how it went.
+func (s *UnitTestSuite) update(name string, id string, arg interface{}) *updateResult {
+	res := &updateResult{}
+	s.env.UpdateWorkflow(name, id, res.callbacks(), arg)
+	return res
+}
+
+// updateThen is update that calls then once the update has completed or
+// been rejected, so that what then sends is handled after it.
+func (s *UnitTestSuite) updateThen(name string, id string, arg interface{}, then func()) *updateResult {
+	res := &updateResult{}
+	callbacks := res.callbacks()
+	complete, reject := callbacks.OnComplete, callbacks.OnReject
+	callbacks.OnComplete = func(result interface{}, err error) {
+		complete(result, err)
+		then()
+	}
+	callbacks.OnReject = func(err error) {
+		reject(err)
+		then()
+	}
+	s.env.UpdateWorkflow(name, id, callbacks, arg)
+	return res
+}
+
+// updateByID is update for a workflow other than the one under test.
+func (s *UnitTestSuite) updateByID(workflowID string, name string, id string, arg interface{}) *updateResult {
+	res := &updateResult{}
+	s.NoError(s.env.UpdateWorkflowByID(workflowID, name, id, res.callbacks(), arg))
+	return res
+}
+
+func (res *updateResult) callbacks() *testsuite.TestUpdateCallback {
+	return &testsuite.TestUpdateCallback{
+		OnAccept: func() { res.accepted = true },
+		OnReject: func(err error) { res.err = err },
+		OnComplete: func(result interface{}, err error) {
+			res.result = result
+			res.err = err
+		},
+	}
+}
+
+func applicationErrorType(err error) string {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		return appErr.Type()
+	}
+	return ""
+}
+
+func (s *UnitTestSuite) pricedCart(cart CartState) CartState {
+	cart = withVariants(cart)
+	pricing, err := PriceCart(cart, DefaultPricingRules)
+	s.NoError(err)
+	cart.Pricing = pricing
+	return cart
 }
 
 func TestUnitTestSuite(t *testing.T) {